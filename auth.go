@@ -0,0 +1,310 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Authenticator adds credentials to outgoing requests.
+//
+// The authenticator of a Client is applied to every request sent by
+// [Client.Do], including the requests of followed redirects, as long as they
+// target the same host as the original request. Credentials are never sent to
+// a different host.
+type Authenticator interface {
+	// Authenticate adds credentials to req. req is a private copy and may be
+	// modified freely.
+	Authenticate(req *http.Request) error
+}
+
+// ChallengeHandler is implemented by an Authenticator that can answer an
+// authentication challenge of the server.
+//
+// When a request authenticated by the Authenticator receives a 401 response,
+// HandleChallenge is called with that response. If it reports retry, the
+// request is authenticated and sent once more, provided its body can be
+// replayed through [http.Request.GetBody].
+type ChallengeHandler interface {
+	HandleChallenge(resp *http.Response) (retry bool, err error)
+}
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as Authenticator.
+type AuthenticatorFunc func(req *http.Request) error
+
+// Authenticate calls f(req).
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// BasicAuth returns an Authenticator using HTTP Basic authentication.
+func BasicAuth(username, password string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// BearerToken returns an Authenticator sending a static bearer token.
+func BearerToken(token string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// TokenSource returns an Authenticator sending the bearer token returned by
+// source. source is called for every request with the request context.
+func TokenSource(source func(ctx context.Context) (string, error)) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		token, err := source(req.Context())
+		if err != nil {
+			return fmt.Errorf("httputil: token source: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// APIKeyLocation is where an API key is sent.
+type APIKeyLocation string
+
+const (
+	// APIKeyHeader sends the API key as a request header.
+	APIKeyHeader APIKeyLocation = "header"
+	// APIKeyQuery sends the API key as a query parameter.
+	APIKeyQuery APIKeyLocation = "query"
+)
+
+// APIKey returns an Authenticator sending value as the header or query
+// parameter name.
+func APIKey(in APIKeyLocation, name, value string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		switch in {
+		case APIKeyHeader:
+			req.Header.Set(name, value)
+		case APIKeyQuery:
+			query := req.URL.Query()
+			query.Set(name, value)
+			u := *req.URL
+			u.RawQuery = query.Encode()
+			req.URL = &u
+		default:
+			return fmt.Errorf("httputil: unsupported API key location %q", in)
+		}
+		return nil
+	})
+}
+
+// authTransport applies an Authenticator to every request sent to the origin
+// of the original request, so that credentials are neither sent to another
+// host or port nor downgraded from https to http by a redirect.
+type authTransport struct {
+	auth Authenticator
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !sameOrigin(originalRequest(req).URL, req.URL) {
+		return t.next.RoundTrip(req)
+	}
+	authReq := req.Clone(req.Context())
+	if err := t.auth.Authenticate(authReq); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(authReq)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	handler, ok := t.auth.(ChallengeHandler)
+	if !ok || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return resp, nil
+	}
+	retry, err := handler.HandleChallenge(resp)
+	if err != nil {
+		drainBody(resp.Body)
+		return nil, err
+	}
+	if !retry {
+		return resp, nil
+	}
+	authReq = req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if authReq.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	if err = t.auth.Authenticate(authReq); err != nil {
+		if authReq.Body != nil {
+			authReq.Body.Close()
+		}
+		drainBody(resp.Body)
+		return nil, err
+	}
+	drainBody(resp.Body)
+	return t.next.RoundTrip(authReq)
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transport.
+func (t *authTransport) CloseIdleConnections() {
+	closeIdleConnections(t.next)
+}
+
+// closeIdleConnections closes the idle connections of rt if supported.
+func closeIdleConnections(rt http.RoundTripper) {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if tr, ok := rt.(closeIdler); ok {
+		tr.CloseIdleConnections()
+	}
+}
+
+// originalRequest returns the first request of the redirect chain of req.
+func originalRequest(req *http.Request) *http.Request {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req
+}
+
+// sameHost reports whether a and b address the same host name.
+func sameHost(a, b *url.URL) bool {
+	return strings.EqualFold(a.Hostname(), b.Hostname())
+}
+
+// sameOrigin reports whether a and b have the same scheme, host name and
+// port, the default port of the scheme if omitted.
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && sameHost(a, b) && effectivePort(a) == effectivePort(b)
+}
+
+// effectivePort returns the port of u, or the default port of its scheme.
+func effectivePort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+// drainBody reads a bounded amount of body and closes it so that the
+// connection can be reused.
+func drainBody(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, 4<<10)
+	body.Close()
+}
+
+// authChallenge is a challenge of a WWW-Authenticate header.
+type authChallenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// parseChallenges parses the challenges of WWW-Authenticate header values
+// (RFC 9110 section 11.6.1). Parameter names are lower-cased, a token68 is
+// stored under the empty name.
+func parseChallenges(values []string) []authChallenge {
+	var challenges []authChallenge
+	for _, s := range values {
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			scheme, rest := parseToken(s)
+			if scheme == "" {
+				break
+			}
+			s = rest
+			challenge := authChallenge{Scheme: scheme, Params: map[string]string{}}
+			if trimmed := strings.TrimLeft(s, " \t"); trimmed != s {
+				token68, after := parseToken68(trimmed)
+				if next := strings.TrimLeft(after, " \t"); token68 != "" && (next == "" || next[0] == ',') {
+					challenge.Params[""] = token68
+					s = after
+				}
+			}
+			for {
+				name, after := parseToken(strings.TrimLeft(s, " \t,"))
+				after = strings.TrimLeft(after, " \t")
+				if name == "" || !strings.HasPrefix(after, "=") {
+					break
+				}
+				after = strings.TrimLeft(after[1:], " \t")
+				var value string
+				if strings.HasPrefix(after, `"`) {
+					value, after = parseQuoted(after)
+				} else {
+					value, after = parseToken(after)
+				}
+				challenge.Params[strings.ToLower(name)] = value
+				s = after
+			}
+			challenges = append(challenges, challenge)
+		}
+	}
+	return challenges
+}
+
+// parseToken splits a leading token off s.
+func parseToken(s string) (token, rest string) {
+	i := 0
+	for i < len(s) && isTokenChar(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// parseToken68 splits a leading token68 off s.
+func parseToken68(s string) (token68, rest string) {
+	i := 0
+	for i < len(s) && (isAlphaNum(s[i]) || strings.IndexByte("-._~+/", s[i]) >= 0) {
+		i++
+	}
+	if i == 0 {
+		return "", s
+	}
+	for i < len(s) && s[i] == '=' {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// isTokenChar reports whether c is a tchar of RFC 9110.
+func isTokenChar(c byte) bool {
+	return isAlphaNum(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// isAlphaNum reports whether c is an ASCII letter or digit.
+func isAlphaNum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseQuoted splits a leading quoted-string off s and unescapes it.
+func parseQuoted(s string) (value, rest string) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:]
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), ""
+}
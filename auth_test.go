@@ -0,0 +1,88 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newEchoAuthServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if to := r.URL.Query().Get("redirect"); to != "" {
+			http.Redirect(w, r, to, http.StatusFound)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization") + "|" + r.Header.Get("X-Api-Key") + "|" + r.URL.Query().Get("api_key")))
+	}))
+}
+
+func TestAuthenticators(t *testing.T) {
+	ts := newEchoAuthServer()
+	defer ts.Close()
+	tests := []struct {
+		opt  ClientOption
+		want string
+	}{
+		{WithBasicAuth("user", "pass"), "Basic dXNlcjpwYXNz||"},
+		{WithBearerToken("token"), "Bearer token||"},
+		{WithTokenSource(func(ctx context.Context) (string, error) { return "source", nil }), "Bearer source||"},
+		{WithAPIKey(APIKeyHeader, "X-Api-Key", "key"), "|key|"},
+		{WithAPIKey(APIKeyQuery, "api_key", "key"), "||key"},
+	}
+	for _, tt := range tests {
+		resp, err := NewClient(context.Background(), tt.opt).Get(ts.URL)
+		assert.Nil(t, err)
+		body, err := ReadString(resp)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, body)
+	}
+}
+
+func TestAuthenticatorRedirect(t *testing.T) {
+	ts := newEchoAuthServer()
+	defer ts.Close()
+	c := NewClient(context.Background(), WithAPIKey(APIKeyHeader, "X-Api-Key", "key"))
+	// same host
+	resp, err := c.Get(ts.URL + "?redirect=/")
+	assert.Nil(t, err)
+	body, err := ReadString(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "|key|", body)
+	// different host
+	other := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	resp, err = c.Get(ts.URL + "?redirect=" + other)
+	assert.Nil(t, err)
+	body, err = ReadString(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "||", body)
+	// different port
+	ts2 := newEchoAuthServer()
+	defer ts2.Close()
+	resp, err = c.Get(ts.URL + "?redirect=" + ts2.URL)
+	assert.Nil(t, err)
+	body, err = ReadString(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "||", body)
+}
+
+func TestSameOrigin(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"https://example.com/a", "https://EXAMPLE.com:443/b", true},
+		{"http://example.com", "http://example.com:80", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://example.com:8443", false},
+		{"https://example.com", "https://api.example.com", false},
+	} {
+		a, _ := url.Parse(tt.a)
+		b, _ := url.Parse(tt.b)
+		assert.Equal(t, tt.want, sameOrigin(a, b), tt.a+" "+tt.b)
+	}
+}
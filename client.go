@@ -4,26 +4,28 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"mime/multipart"
-	"net"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
+	"slices"
+	"strings"
 	"time"
-
-	"golang.org/x/net/publicsuffix"
 )
 
 type Client struct {
-	client *http.Client
-	ctx    context.Context
-	opts   *ClientOptions
+	client    *http.Client
+	transport http.RoundTripper
+	ctx       context.Context
+	opts      *ClientOptions
+	baseURL   *url.URL
+	err       error
 }
 
 // NewClient new client
 func NewClient(ctx context.Context, opts ...ClientOption) *Client {
-	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	options := &ClientOptions{
 		dialTimeout:         1 * time.Minute,
 		keepAliveTimeout:    0,
@@ -32,54 +34,178 @@ func NewClient(ctx context.Context, opts ...ClientOption) *Client {
 	for _, opt := range opts {
 		opt(options)
 	}
-	var transport http.RoundTripper
-	if options.proxy != "" {
-		transport = &http.Transport{
-			Proxy: func(_ *http.Request) (*url.URL, error) {
-				return url.Parse(options.proxy)
-			},
-			DialContext: (&net.Dialer{
-				Timeout:   options.dialTimeout,
-				KeepAlive: options.keepAliveTimeout,
-			}).DialContext,
-			TLSHandshakeTimeout: options.tlsHandshakeTimeout,
+	var jar http.CookieJar
+	switch {
+	case options.cookieJar != nil:
+		jar = options.cookieJar
+	case !options.noCookies:
+		jar = newDefaultJar()
+	}
+	transport, err := newTransport(options)
+	if err != nil {
+		transport = http.DefaultTransport
+	}
+	base := transport
+	for i := len(options.middlewares) - 1; i >= 0; i-- {
+		transport = options.middlewares[i](transport)
+	}
+	if options.authenticator != nil {
+		transport = &authTransport{auth: options.authenticator, next: transport}
+	}
+	client := &http.Client{
+		Jar:       jar,
+		Transport: transport,
+	}
+	if options.redirects != nil {
+		client.CheckRedirect = options.redirects.checkRedirect
+	}
+	var baseURL *url.URL
+	if options.baseURL != "" {
+		var baseErr error
+		if baseURL, baseErr = parseBaseURL(options.baseURL); err == nil {
+			err = baseErr
 		}
 	}
 	return &Client{
-		client: &http.Client{
-			Jar:       jar,
-			Transport: transport,
-		},
-		ctx:  ctx,
-		opts: options,
+		client:    client,
+		transport: base,
+		ctx:       ctx,
+		opts:      options,
+		baseURL:   baseURL,
+		err:       err,
+	}
+}
+
+// Err returns the error of an invalid client configuration, such as an
+// unreadable certificate file. Every request of such a client fails with it.
+func (c *Client) Err() error {
+	return c.err
+}
+
+// Cookies returns the cookies the client sends to the URL u, or nil if it
+// has no cookie jar. A relative u is resolved against the base URL.
+func (c *Client) Cookies(u string) []*http.Cookie {
+	parsed, err := url.Parse(u)
+	if err != nil || c.client.Jar == nil {
+		return nil
 	}
+	return c.client.Jar.Cookies(c.resolveURL(parsed))
+}
+
+// SetCookies stores cookies in the cookie jar of the client as if they were
+// set by a response of the URL u.
+func (c *Client) SetCookies(u string, cookies ...*http.Cookie) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if c.client.Jar == nil {
+		return errors.New("httputil: client has no cookie jar")
+	}
+	c.client.Jar.SetCookies(c.resolveURL(parsed), cookies)
+	return nil
 }
 
 // Close close
 func (c *Client) Close() {
 	c.client.CloseIdleConnections()
+	closeIdleConnections(c.transport)
+}
+
+// parseBaseURL parses the URL of WithBaseURL, so that relative references
+// resolve below its path.
+func parseBaseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err == nil && (u.Scheme == "" || u.Host == "") {
+		err = errors.New("not an absolute URL")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("httputil: invalid base URL %q: %w", rawURL, err)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	}
+	return u, nil
+}
+
+// resolveURL resolves a relative URL against the base URL of the client.
+func (c *Client) resolveURL(u *url.URL) *url.URL {
+	if c.baseURL == nil || u.IsAbs() {
+		return u
+	}
+	return c.baseURL.ResolveReference(u)
 }
 
+// fillHeader sets the headers of a request: the headers of the request
+// options replace the headers of the request, which take precedence over the
+// User-Agent and default headers of the client.
 func (c *Client) fillHeader(header http.Header, opts *RequestOptions) {
-	if c.opts.userAgent != "" {
+	if c.opts.userAgent != "" && header.Get("User-Agent") == "" {
 		header.Set("User-Agent", c.opts.userAgent)
 	}
+	for key, values := range c.opts.defaultHeaders {
+		if _, ok := header[key]; !ok {
+			header[key] = slices.Clone(values)
+		}
+	}
 	if opts.referer != "" {
 		header.Set("Referer", opts.referer)
 	}
 	if opts.contentType != "" {
 		header.Set("Content-Type", opts.contentType)
 	}
+	for key := range opts.headers {
+		header.Del(key)
+	}
 	for key, values := range opts.headers {
 		for _, value := range values {
-			if header.Get(key) != "" {
-				continue
-			}
 			header.Add(key, value)
 		}
 	}
 }
 
+// fillQuery sets the query parameters of a request: the parameters of the
+// request options replace the parameters of u, which take precedence over
+// the default query parameters of the client. The other parameters of u are
+// kept as they are written.
+func (c *Client) fillQuery(u *url.URL, opts *RequestOptions) {
+	if len(c.opts.defaultQuery) == 0 && len(opts.query) == 0 {
+		return
+	}
+	var parts []string
+	present := make(map[string]bool)
+	for part := range strings.SplitSeq(u.RawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if opts.query.Has(key) {
+			continue
+		}
+		present[key] = true
+		parts = append(parts, part)
+	}
+	if len(opts.query) > 0 {
+		parts = append(parts, opts.query.Encode())
+	}
+	missing := url.Values{}
+	for key, values := range c.opts.defaultQuery {
+		if !present[key] && !opts.query.Has(key) {
+			missing[key] = values
+		}
+	}
+	if len(missing) > 0 {
+		parts = append(parts, missing.Encode())
+	}
+	u.RawQuery = strings.Join(parts, "&")
+}
+
 // Do sends an HTTP request and returns an HTTP response, following
 // policy (such as redirects, cookies, auth) as configured on the
 // client.
@@ -118,20 +244,67 @@ func (c *Client) fillHeader(header http.Header, opts *RequestOptions) {
 // Any returned error will be of type [*url.Error]. The url.Error
 // value's Timeout method will report true if the request timed out.
 func (c *Client) Do(req *http.Request, opts ...RequestOption) (resp *http.Response, err error) {
+	if c.err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, c.err
+	}
 	options := &RequestOptions{}
 	for _, opt := range opts {
 		opt(options)
 	}
+	if options.err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, options.err
+	}
+	if u := c.resolveURL(req.URL); u != req.URL {
+		req.URL = u
+		req.Host = u.Host
+	}
 	c.fillHeader(req.Header, options)
+	c.fillQuery(req.URL, options)
+	if c.opts.compression != "" {
+		if err = compressRequest(req, c.opts.compression, c.opts.compressionMinSize); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return
+		}
+	}
 	return c.client.Do(req)
 }
 
+// newRequest returns a request of the client for the URL, expanded with the
+// variables of WithURITemplate.
+func (c *Client) newRequest(method, url string, body io.Reader, opts []RequestOption) (*http.Request, error) {
+	url, err := expandURL(url, opts)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(c.ctx, method, url, body)
+}
+
+// expandURL expands url with the variables of WithURITemplate, if set.
+func expandURL(url string, opts []RequestOption) (string, error) {
+	options := &RequestOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if !options.uriTemplate {
+		return url, nil
+	}
+	return Expand(url, options.uriVars)
+}
+
 // Get issues a GET to the specified URL.
 //
 // When err is nil, resp always contains a non-nil resp.Body.
 // Caller should close resp.Body when done reading from it.
 func (c *Client) Get(url string, opts ...RequestOption) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, url, nil)
+	req, err := c.newRequest(http.MethodGet, url, nil, opts)
 	if err != nil {
 		return
 	}
@@ -143,7 +316,7 @@ func (c *Client) Get(url string, opts ...RequestOption) (resp *http.Response, er
 // When err is nil, resp always contains a non-nil resp.Body.
 // Caller should close resp.Body when done reading from it.
 func (c *Client) Head(url string, opts ...RequestOption) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(c.ctx, http.MethodHead, url, nil)
+	req, err := c.newRequest(http.MethodHead, url, nil, opts)
 	if err != nil {
 		return
 	}
@@ -155,7 +328,7 @@ func (c *Client) Head(url string, opts ...RequestOption) (resp *http.Response, e
 // When err is nil, resp always contains a non-nil resp.Body.
 // Caller should close resp.Body when done reading from it.
 func (c *Client) Post(url string, contentType string, body io.Reader, opts ...RequestOption) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, url, body)
+	req, err := c.newRequest(http.MethodPost, url, body, opts)
 	if err != nil {
 		return
 	}
@@ -167,7 +340,7 @@ func (c *Client) Post(url string, contentType string, body io.Reader, opts ...Re
 // When err is nil, resp always contains a non-nil resp.Body.
 // Caller should close resp.Body when done reading from it.
 func (c *Client) Put(url string, contentType string, body io.Reader, opts ...RequestOption) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(c.ctx, http.MethodPut, url, body)
+	req, err := c.newRequest(http.MethodPut, url, body, opts)
 	if err != nil {
 		return
 	}
@@ -179,7 +352,7 @@ func (c *Client) Put(url string, contentType string, body io.Reader, opts ...Req
 // When err is nil, resp always contains a non-nil resp.Body.
 // Caller should close resp.Body when done reading from it.
 func (c *Client) Patch(url string, contentType string, body io.Reader, opts ...RequestOption) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(c.ctx, http.MethodPatch, url, body)
+	req, err := c.newRequest(http.MethodPatch, url, body, opts)
 	if err != nil {
 		return
 	}
@@ -191,7 +364,7 @@ func (c *Client) Patch(url string, contentType string, body io.Reader, opts ...R
 // When err is nil, resp always contains a non-nil resp.Body.
 // Caller should close resp.Body when done reading from it.
 func (c *Client) Delete(url string, opts ...RequestOption) (resp *http.Response, err error) {
-	req, err := http.NewRequestWithContext(c.ctx, http.MethodDelete, url, nil)
+	req, err := c.newRequest(http.MethodDelete, url, nil, opts)
 	if err != nil {
 		return
 	}
@@ -209,6 +382,43 @@ func (c *Client) PostForm(url string, data url.Values, opts ...RequestOption) (r
 	return c.Post(url, "application/x-www-form-urlencoded", bytes.NewBufferString(data.Encode()), opts...)
 }
 
+// PostFormStruct issues a POST to the specified URL, with the fields of v
+// URL-encoded as the request body.
+//
+// v is encoded like WithQuery with `form` tags instead of `url` tags, so
+// nested structs and maps use bracketed keys such as "a[b][c]", and slices of
+// structs indexed keys such as "a[0][b]".
+//
+// The Content-Type header is set to application/x-www-form-urlencoded.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) PostFormStruct(url string, v any, opts ...RequestOption) (resp *http.Response, err error) {
+	data, err := encodeValues(v, "form")
+	if err != nil {
+		return nil, err
+	}
+	return c.PostForm(url, data, opts...)
+}
+
+// PostMultipartStruct issues a POST to the specified URL, with the fields of
+// v as multipart form.
+//
+// v is encoded like PostFormStruct, and its UploadFile, *os.File and other
+// io.Reader fields are sent as file parts named after their keys.
+//
+// The Content-Type header is set to multipart/form-data.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func (c *Client) PostMultipartStruct(url string, v any, opts ...RequestOption) (resp *http.Response, err error) {
+	e := &valuesEncoder{tag: "form", files: new([]*UploadFile)}
+	if err := e.encode(v); err != nil {
+		return nil, err
+	}
+	return c.PostFormFiles(url, e.values, *e.files, opts...)
+}
+
 // PostFormFiles issues a POST to the specified URL, with data's keys and
 // values URL-encoded as the request body, and files as multipart form.
 //
@@ -240,6 +450,10 @@ func (c *Client) PostFormFiles(url string, data url.Values, files []*UploadFile,
 	if err := writer.Close(); err != nil {
 		return nil, err
 	}
+	url, err = expandURL(url, opts)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest(http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
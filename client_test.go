@@ -0,0 +1,91 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientDefaults(t *testing.T) {
+	type echo struct {
+		Path   string
+		Query  url.Values
+		Header http.Header
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(echo{Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header})
+	}))
+	defer ts.Close()
+
+	c := NewClient(context.Background(),
+		WithBaseURL(ts.URL+"/api"),
+		WithUserAgent("client/1"),
+		WithDefaultHeaders(http.Header{"X-A": {"default"}, "X-B": {"default"}, "x-c": {"1", "2"}}),
+		WithDefaultHeaders(http.Header{"X-B": {"default2"}}),
+		WithDefaultQuery(url.Values{"key": {"k"}, "page": {"1"}}),
+	)
+	assert.Nil(t, c.Err())
+
+	resp, err := c.Get("/v1/users?page=2")
+	assert.Nil(t, err)
+	res, err := ReadJSON[echo](resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "/v1/users", res.Path)
+	assert.Equal(t, url.Values{"key": {"k"}, "page": {"2"}}, res.Query)
+	assert.Equal(t, "client/1", res.Header.Get("User-Agent"))
+	assert.Equal(t, "default", res.Header.Get("X-A"))
+	assert.Equal(t, "default2", res.Header.Get("X-B"))
+	assert.Equal(t, []string{"1", "2"}, res.Header.Values("X-C"))
+
+	// request options replace request headers, which replace the defaults
+	req, _ := http.NewRequest(http.MethodGet, "users", nil)
+	req.Header.Set("User-Agent", "request")
+	req.Header.Set("X-A", "request")
+	req.Header.Set("X-B", "request")
+	resp, err = c.Do(req, WithHeaders(http.Header{"X-B": {"option"}}))
+	assert.Nil(t, err)
+	res, err = ReadJSON[echo](resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "/api/users", res.Path)
+	assert.Equal(t, "request", res.Header.Get("User-Agent"))
+	assert.Equal(t, "request", res.Header.Get("X-A"))
+	assert.Equal(t, []string{"option"}, res.Header.Values("X-B"))
+
+	// absolute URLs ignore the base URL
+	resp, err = c.Get(ts.URL + "/other")
+	assert.Nil(t, err)
+	res, _ = ReadJSON[echo](resp)
+	assert.Equal(t, "/other", res.Path)
+
+	assert.NotNil(t, NewClient(context.Background(), WithBaseURL("/api")).Err())
+}
+
+// wrappedTransport replaces http.DefaultTransport like instrumentation does.
+type wrappedTransport struct {
+	http.RoundTripper
+}
+
+func TestReplacedDefaultTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = wrappedTransport{defaultTransport}
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	c := NewClient(context.Background(), WithHostOverrides(map[string]string{"api.test": "127.0.0.1"}))
+	assert.Nil(t, c.Err())
+	resp, err := c.Get(strings.Replace(ts.URL, "127.0.0.1", "api.test", 1))
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "ok", body)
+	assert.Nil(t, NewClient(context.Background(), WithProxy("http://127.0.0.1:1")).Err())
+	assert.Nil(t, NewClient(context.Background(), WithDoH(DoHConfig{URL: "https://dns.test/dns-query"})).Err())
+}
@@ -0,0 +1,88 @@
+package httputil
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressWriterFunc creates a compressing writer on top of w.
+type compressWriterFunc func(w io.Writer) (io.WriteCloser, error)
+
+// compressWriter returns the writer constructor of a Content-Encoding.
+func compressWriter(encoding string) (compressWriterFunc, error) {
+	switch encoding {
+	case "gzip":
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}, nil
+	case "deflate":
+		// "deflate" in HTTP is the zlib format (RFC 9110 section 8.4.1.2)
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return zlib.NewWriter(w), nil
+		}, nil
+	case "zstd":
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		}, nil
+	}
+	return nil, fmt.Errorf("httputil: unsupported request compression %q", encoding)
+}
+
+// compressReader returns a reader streaming the compressed content of body.
+// body is closed once it has been fully consumed or the reader is closed.
+func compressReader(body io.ReadCloser, newWriter compressWriterFunc) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		w, err := newWriter(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(w, body); err != nil {
+			w.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr
+}
+
+// compressRequest replaces the body of req with a compressed stream and sets
+// the Content-Encoding header. Requests without a body, with a body known to
+// be smaller than minSize, or already carrying a Content-Encoding are left
+// untouched.
+func compressRequest(req *http.Request, encoding string, minSize int64) error {
+	if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	if req.ContentLength > 0 && req.ContentLength < minSize {
+		return nil
+	}
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	newWriter, err := compressWriter(encoding)
+	if err != nil {
+		return err
+	}
+	req.Body = compressReader(req.Body, newWriter)
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return compressReader(body, newWriter), nil
+		}
+	}
+	req.ContentLength = -1
+	req.Header.Del("Content-Length")
+	req.Header.Set("Content-Encoding", encoding)
+	return nil
+}
@@ -0,0 +1,81 @@
+package httputil
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDecompressServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/echo", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			zr, err := gzip.NewReader(r.Body)
+			assert.Nil(t, err)
+			body = zr
+		case "deflate":
+			zr, err := zlib.NewReader(r.Body)
+			assert.Nil(t, err)
+			body = zr
+		case "zstd":
+			zr, err := zstd.NewReader(r.Body)
+			assert.Nil(t, err)
+			defer zr.Close()
+			body = zr
+		}
+		w.Header().Set("X-Content-Encoding", r.Header.Get("Content-Encoding"))
+		io.Copy(w, body)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRequestCompression(t *testing.T) {
+	ts := newDecompressServer(t)
+	defer ts.Close()
+	for _, encoding := range []string{"gzip", "deflate", "zstd"} {
+		c := NewClient(context.Background(), WithRequestCompression(encoding, 0))
+		resp, err := c.PostJSON(ts.URL+"/echo", map[string]string{"foo": "bar"})
+		assert.Nil(t, err)
+		assert.Equal(t, encoding, resp.Header.Get("X-Content-Encoding"))
+		body, err := ReadString(resp)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"foo":"bar"}`, body)
+	}
+}
+
+func TestRequestCompressionRedirect(t *testing.T) {
+	ts := newDecompressServer(t)
+	defer ts.Close()
+	c := NewClient(context.Background(), WithRequestCompression("gzip", 0))
+	resp, err := c.Post(ts.URL+"/redirect", "text/plain", strings.NewReader("hello world"))
+	assert.Nil(t, err)
+	assert.Equal(t, "gzip", resp.Header.Get("X-Content-Encoding"))
+	body, err := ReadString(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", body)
+}
+
+func TestRequestCompressionMinSize(t *testing.T) {
+	ts := newDecompressServer(t)
+	defer ts.Close()
+	c := NewClient(context.Background(), WithRequestCompression("gzip", 1024))
+	resp, err := c.Post(ts.URL+"/echo", "text/plain", strings.NewReader("hello world"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", resp.Header.Get("X-Content-Encoding"))
+	body, err := ReadString(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", body)
+}
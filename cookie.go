@@ -0,0 +1,504 @@
+package httputil
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// netscapeHttpOnlyPrefix marks HttpOnly cookies in the cookies.txt format.
+const netscapeHttpOnlyPrefix = "#HttpOnly_"
+
+// PersistentJar is an http.CookieJar that can be saved to and loaded from a
+// JSON file or a Netscape cookies.txt file, as written by curl and browser
+// extensions. It follows RFC 6265, and rejects SameSite=None, Partitioned,
+// "__Secure-" and "__Host-" cookies without the Secure attribute.
+//
+// SameSite and Partitioned attributes are only kept by the JSON format.
+// Session cookies are saved too, so that sessions survive restarts.
+type PersistentJar struct {
+	filename string
+
+	mu      sync.Mutex
+	entries map[string]*jarCookie
+	now     func() time.Time
+}
+
+// defaultJar is the default cookie jar of a client, a cookiejar.Jar whose
+// stored cookies are also recorded in a PersistentJar, so that
+// Client.Snapshot can list them.
+type defaultJar struct {
+	*cookiejar.Jar
+	recorded *PersistentJar
+}
+
+// newDefaultJar returns a new defaultJar using the public suffix list.
+func newDefaultJar() *defaultJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &defaultJar{Jar: jar, recorded: &PersistentJar{}}
+}
+
+// SetCookies implements http.CookieJar.
+func (j *defaultJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	j.recorded.SetCookies(u, cookies)
+}
+
+// jarCookie is a cookie of a PersistentJar, and its JSON representation.
+type jarCookie struct {
+	Name        string    `json:"name"`
+	Value       string    `json:"value"`
+	Domain      string    `json:"domain"`
+	Path        string    `json:"path"`
+	HostOnly    bool      `json:"host_only,omitempty"`
+	Secure      bool      `json:"secure,omitempty"`
+	HttpOnly    bool      `json:"http_only,omitempty"`
+	SameSite    string    `json:"same_site,omitempty"`
+	Partitioned bool      `json:"partitioned,omitempty"`
+	Expires     time.Time `json:"expires,omitzero"`
+	Created     time.Time `json:"created,omitzero"`
+}
+
+// NewPersistentJar returns a jar saved to filename by Save, loading the
+// cookies of filename if it exists. Files with a ".txt" extension use the
+// Netscape cookies.txt format, other files JSON. An empty filename returns an
+// in-memory jar.
+func NewPersistentJar(filename string) (*PersistentJar, error) {
+	j := &PersistentJar{filename: filename}
+	if filename == "" {
+		return j, nil
+	}
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if j.netscape() {
+		err = j.ReadNetscape(f)
+	} else {
+		err = j.ReadJSON(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("httputil: load cookies %s: %w", filename, err)
+	}
+	return j, nil
+}
+
+// Save writes the unexpired cookies of the jar to its file, replacing it
+// atomically.
+func (j *PersistentJar) Save() error {
+	if j.filename == "" {
+		return errors.New("httputil: cookie jar has no file")
+	}
+	f, err := os.CreateTemp(filepath.Dir(j.filename), filepath.Base(j.filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if j.netscape() {
+		err = j.WriteNetscape(f)
+	} else {
+		err = j.WriteJSON(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), j.filename)
+}
+
+// netscape reports whether the file of the jar uses the cookies.txt format.
+func (j *PersistentJar) netscape() bool {
+	return strings.EqualFold(filepath.Ext(j.filename), ".txt")
+}
+
+// SetCookies implements http.CookieJar.
+func (j *PersistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	host := canonicalCookieHost(u.Hostname())
+	if host == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.time()
+	for _, cookie := range cookies {
+		c, ok := newJarCookie(cookie, host, u.EscapedPath(), now)
+		if !ok {
+			continue
+		}
+		key := c.key()
+		if !c.Expires.IsZero() && !c.Expires.After(now) {
+			delete(j.entries, key)
+			continue
+		}
+		if old, ok := j.entries[key]; ok {
+			c.Created = old.Created
+		}
+		if j.entries == nil {
+			j.entries = make(map[string]*jarCookie)
+		}
+		j.entries[key] = c
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *PersistentJar) Cookies(u *url.URL) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	host := canonicalCookieHost(u.Hostname())
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.time()
+	var matched []*jarCookie
+	for key, c := range j.entries {
+		if !c.Expires.IsZero() && !c.Expires.After(now) {
+			delete(j.entries, key)
+			continue
+		}
+		if c.domainMatch(host) && c.pathMatch(path) && (!c.Secure || u.Scheme == "https") {
+			matched = append(matched, c)
+		}
+	}
+	// longer paths first, then older cookies first (RFC 6265 section 5.4)
+	slices.SortFunc(matched, func(a, b *jarCookie) int {
+		if n := cmp.Compare(len(b.Path), len(a.Path)); n != 0 {
+			return n
+		}
+		if n := a.Created.Compare(b.Created); n != 0 {
+			return n
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	cookies := make([]*http.Cookie, 0, len(matched))
+	for _, c := range matched {
+		cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return cookies
+}
+
+// All returns the unexpired cookies of the jar, with their attributes.
+func (j *PersistentJar) All() []*http.Cookie {
+	var cookies []*http.Cookie
+	for _, c := range j.snapshot() {
+		cookies = append(cookies, c.httpCookie())
+	}
+	return cookies
+}
+
+// WriteJSON writes the unexpired cookies of the jar to w as JSON.
+func (j *PersistentJar) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(j.snapshot())
+}
+
+// ReadJSON adds the unexpired cookies written by WriteJSON to the jar.
+func (j *PersistentJar) ReadJSON(r io.Reader) error {
+	var cookies []*jarCookie
+	if err := json.NewDecoder(r).Decode(&cookies); err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		c.Domain = canonicalCookieHost(c.Domain)
+		if c.Name == "" || c.Domain == "" || !strings.HasPrefix(c.Path, "/") {
+			return fmt.Errorf("invalid cookie %q", c.Name)
+		}
+	}
+	j.add(cookies)
+	return nil
+}
+
+// WriteNetscape writes the unexpired cookies of the jar to w in the Netscape
+// cookies.txt format. Session cookies have an expiry of 0.
+func (j *PersistentJar) WriteNetscape(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n\n")
+	for _, c := range j.snapshot() {
+		domain := c.Domain
+		if !c.HostOnly {
+			domain = "." + domain
+		}
+		if c.HttpOnly {
+			domain = netscapeHttpOnlyPrefix + domain
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, netscapeBool(!c.HostOnly), c.Path, netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+	return bw.Flush()
+}
+
+// ReadNetscape adds the unexpired cookies of a Netscape cookies.txt file to
+// the jar.
+func (j *PersistentJar) ReadNetscape(r io.Reader) error {
+	var cookies []*jarCookie
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, netscapeHttpOnlyPrefix)
+		line = strings.TrimPrefix(line, netscapeHttpOnlyPrefix)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			// cookies with an empty value may lack the last field
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return fmt.Errorf("line %d: expected 7 fields, got %d", n, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid expiry %q", n, fields[4])
+		}
+		c := &jarCookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Domain:   canonicalCookieHost(fields[0]),
+			Path:     fields[2],
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		if c.Name == "" || c.Domain == "" || !strings.HasPrefix(c.Path, "/") {
+			return fmt.Errorf("line %d: invalid cookie", n)
+		}
+		cookies = append(cookies, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	j.add(cookies)
+	return nil
+}
+
+// add adds loaded cookies to the jar, skipping the expired ones.
+func (j *PersistentJar) add(cookies []*jarCookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.time()
+	for _, c := range cookies {
+		if !c.Expires.IsZero() && !c.Expires.After(now) {
+			continue
+		}
+		if c.Created.IsZero() {
+			c.Created = now
+		}
+		if j.entries == nil {
+			j.entries = make(map[string]*jarCookie)
+		}
+		j.entries[c.key()] = c
+	}
+}
+
+// snapshot returns copies of the unexpired cookies of the jar, sorted by
+// domain, path and name.
+func (j *PersistentJar) snapshot() []jarCookie {
+	j.mu.Lock()
+	now := j.time()
+	cookies := make([]jarCookie, 0, len(j.entries))
+	for _, c := range j.entries {
+		if c.Expires.IsZero() || c.Expires.After(now) {
+			cookies = append(cookies, *c)
+		}
+	}
+	j.mu.Unlock()
+	slices.SortFunc(cookies, func(a, b jarCookie) int {
+		return cmp.Or(strings.Compare(a.Domain, b.Domain), strings.Compare(a.Path, b.Path), strings.Compare(a.Name, b.Name))
+	})
+	return cookies
+}
+
+// time returns the current time.
+func (j *PersistentJar) time() time.Time {
+	if j.now != nil {
+		return j.now()
+	}
+	return time.Now()
+}
+
+// newJarCookie returns the cookie set by a response from host for a request
+// of path, or false if the cookie must be ignored.
+func newJarCookie(cookie *http.Cookie, host, path string, now time.Time) (*jarCookie, bool) {
+	if cookie.Name == "" && cookie.Value == "" {
+		return nil, false
+	}
+	c := &jarCookie{
+		Name:        cookie.Name,
+		Value:       cookie.Value,
+		Path:        cookie.Path,
+		Secure:      cookie.Secure,
+		HttpOnly:    cookie.HttpOnly,
+		SameSite:    sameSiteString(cookie.SameSite),
+		Partitioned: cookie.Partitioned,
+		Created:     now,
+	}
+	if (c.SameSite == "None" || c.Partitioned || strings.HasPrefix(c.Name, "__Secure-") || strings.HasPrefix(c.Name, "__Host-")) && !c.Secure {
+		return nil, false
+	}
+	domain := canonicalCookieHost(cookie.Domain)
+	switch {
+	case domain == "" || domain == host:
+		c.Domain, c.HostOnly = host, cookie.Domain == ""
+	case net.ParseIP(host) != nil:
+		// IP addresses only set host-only cookies
+		return nil, false
+	case !strings.HasSuffix(host, "."+domain):
+		return nil, false
+	default:
+		c.Domain = domain
+	}
+	if !c.HostOnly {
+		// domain cookies of a public suffix such as "co.uk" are rejected,
+		// unless the host is the public suffix itself
+		if suffix, _ := publicsuffix.PublicSuffix(c.Domain); suffix == c.Domain {
+			if c.Domain != host {
+				return nil, false
+			}
+			c.HostOnly = true
+		}
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		c.Path = defaultCookiePath(path)
+	}
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.HostOnly || c.Path != "/") {
+		return nil, false
+	}
+	switch {
+	case cookie.MaxAge < 0:
+		c.Expires = now
+	case cookie.MaxAge > 0:
+		c.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+	case !cookie.Expires.IsZero():
+		c.Expires = cookie.Expires
+		if !c.Expires.After(now) {
+			c.Expires = now
+		}
+	}
+	return c, true
+}
+
+// httpCookie returns c as a Set-Cookie of its domain.
+func (c *jarCookie) httpCookie() *http.Cookie {
+	cookie := &http.Cookie{
+		Name:        c.Name,
+		Value:       c.Value,
+		Path:        c.Path,
+		Domain:      c.Domain,
+		Expires:     c.Expires,
+		Secure:      c.Secure,
+		HttpOnly:    c.HttpOnly,
+		SameSite:    parseSameSite(c.SameSite),
+		Partitioned: c.Partitioned,
+	}
+	if c.HostOnly {
+		cookie.Domain = ""
+	}
+	return cookie
+}
+
+// key returns the key identifying c in a jar.
+func (c *jarCookie) key() string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+// domainMatch reports whether c is sent to host.
+func (c *jarCookie) domainMatch(host string) bool {
+	if host == c.Domain {
+		return true
+	}
+	return !c.HostOnly && strings.HasSuffix(host, "."+c.Domain) && net.ParseIP(host) == nil
+}
+
+// pathMatch reports whether c is sent for path.
+func (c *jarCookie) pathMatch(path string) bool {
+	if path == c.Path {
+		return true
+	}
+	return strings.HasPrefix(path, c.Path) && (strings.HasSuffix(c.Path, "/") || path[len(c.Path)] == '/')
+}
+
+// canonicalCookieHost returns host lower-cased, without a leading or
+// trailing dot or IPv6 brackets.
+func canonicalCookieHost(host string) string {
+	return strings.Trim(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
+
+// defaultCookiePath returns the default path of a cookie set for path
+// (RFC 6265 section 5.1.4).
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// netscapeBool formats b for the cookies.txt format.
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// sameSiteString returns the attribute value of s.
+func sameSiteString(s http.SameSite) string {
+	switch s {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
+
+// parseSameSite parses an attribute value returned by sameSiteString.
+func parseSameSite(s string) http.SameSite {
+	switch s {
+	case "Lax":
+		return http.SameSiteLaxMode
+	case "Strict":
+		return http.SameSiteStrictMode
+	case "None":
+		return http.SameSiteNoneMode
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cookieNames(cookies []*http.Cookie) []string {
+	var names []string
+	for _, c := range cookies {
+		names = append(names, c.Name+"="+c.Value)
+	}
+	return names
+}
+
+func TestPersistentJar(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	jar, err := NewPersistentJar("")
+	assert.Nil(t, err)
+	jar.now = func() time.Time { return now }
+
+	u, _ := url.Parse("https://www.example.com/a/b")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.com", Path: "/"},
+		{Name: "secure", Value: "3", Secure: true, SameSite: http.SameSiteNoneMode, Partitioned: true, HttpOnly: true},
+		{Name: "short", Value: "4", MaxAge: 60},
+		{Name: "suffix", Value: "x", Domain: "com"},
+		{Name: "other", Value: "x", Domain: "other.com"},
+		{Name: "none", Value: "x", SameSite: http.SameSiteNoneMode},
+		{Name: "partitioned", Value: "x", Partitioned: true},
+		{Name: "__Host-id", Value: "x", Secure: true, Domain: "example.com", Path: "/"},
+		{Name: "__Host-ok", Value: "5", Secure: true, Path: "/"},
+	})
+
+	get := func(raw string) []string {
+		u, _ := url.Parse(raw)
+		return cookieNames(jar.Cookies(u))
+	}
+	assert.Equal(t, []string{"host=1", "secure=3", "short=4", "__Host-ok=5", "domain=2"}, get("https://www.example.com/a/c"))
+	assert.Equal(t, []string{"domain=2"}, get("https://api.example.com/a"))
+	assert.Equal(t, []string{"domain=2"}, get("http://www.example.com/"))
+	assert.Equal(t, []string{"__Host-ok=5", "domain=2"}, get("https://www.example.com/ab"))
+	assert.Nil(t, get("ftp://www.example.com/a/c"))
+
+	// deletion and expiry
+	jar.SetCookies(u, []*http.Cookie{{Name: "host", MaxAge: -1}})
+	now = now.Add(time.Minute)
+	assert.Equal(t, []string{"secure=3", "__Host-ok=5", "domain=2"}, get("https://www.example.com/a/c"))
+
+	// IP addresses only get host-only cookies
+	ip, _ := url.Parse("http://127.0.0.1/")
+	jar.SetCookies(ip, []*http.Cookie{{Name: "ip", Value: "6"}, {Name: "bad", Value: "x", Domain: "0.0.1"}})
+	assert.Equal(t, []string{"ip=6"}, get("http://127.0.0.1/"))
+}
+
+func TestPersistentJarFormats(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	u, _ := url.Parse("https://www.example.com/")
+	cookies := []*http.Cookie{
+		{Name: "session", Value: "s", Path: "/"},
+		{Name: "id", Value: "i", Domain: "example.com", Path: "/", Expires: expires, Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode, Partitioned: true},
+	}
+	for _, name := range []string{"cookies.json", "cookies.txt"} {
+		filename := filepath.Join(t.TempDir(), name)
+		jar, err := NewPersistentJar(filename)
+		assert.Nil(t, err)
+		jar.SetCookies(u, cookies)
+		assert.Nil(t, jar.Save())
+
+		loaded, err := NewPersistentJar(filename)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"id=i", "session=s"}, cookieNames(loaded.Cookies(u)), name)
+		all := loaded.All()
+		assert.Len(t, all, 2)
+		assert.Equal(t, "example.com", all[0].Domain)
+		assert.True(t, all[0].Expires.Equal(expires))
+		assert.True(t, all[0].Secure && all[0].HttpOnly)
+		assert.Equal(t, name == "cookies.json", all[0].Partitioned)
+		if name == "cookies.json" {
+			assert.Equal(t, http.SameSiteStrictMode, all[0].SameSite)
+		}
+		assert.Equal(t, "", all[1].Domain)
+		assert.True(t, all[1].Expires.IsZero())
+	}
+
+	var buf bytes.Buffer
+	jar, _ := NewPersistentJar("")
+	jar.SetCookies(u, cookies)
+	assert.Nil(t, jar.WriteNetscape(&buf))
+	assert.Contains(t, buf.String(), "#HttpOnly_.example.com\tTRUE\t/\tTRUE\t")
+	assert.Contains(t, buf.String(), "www.example.com\tFALSE\t/\tFALSE\t0\tsession\ts\n")
+
+	// curl cookies, including an expired one
+	jar, _ = NewPersistentJar("")
+	assert.Nil(t, jar.ReadNetscape(strings.NewReader("# Netscape HTTP Cookie File\n.example.com\tTRUE\t/\tFALSE\t0\ta\t1\nexample.com\tFALSE\t/\tFALSE\t1\told\t2\n")))
+	assert.Equal(t, []string{"a=1"}, cookieNames(jar.Cookies(u)))
+	assert.NotNil(t, jar.ReadNetscape(strings.NewReader("example.com\tFALSE\t/\n")))
+}
+
+func TestClientCookies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "server", Value: "1"})
+		var names []string
+		for _, c := range r.Cookies() {
+			names = append(names, c.Name)
+		}
+		w.Write([]byte(strings.Join(names, ",")))
+	}))
+	defer ts.Close()
+
+	c := NewClient(context.Background())
+	assert.Nil(t, c.SetCookies(ts.URL, &http.Cookie{Name: "client", Value: "2"}))
+	resp, err := c.Get(ts.URL)
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "client", body)
+	assert.Equal(t, []string{"client=2", "server=1"}, cookieNames(c.Cookies(ts.URL)))
+
+	jar, _ := NewPersistentJar("")
+	c = NewClient(context.Background(), WithCookieJar(jar))
+	resp, err = c.Get(ts.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Len(t, jar.All(), 1)
+
+	c = NewClient(context.Background(), WithoutCookies())
+	for range 2 {
+		resp, err = c.Get(ts.URL)
+		assert.Nil(t, err)
+		body, _ = ReadString(resp)
+		assert.Equal(t, "", body)
+	}
+	assert.Nil(t, c.Cookies(ts.URL))
+	assert.NotNil(t, c.SetCookies(ts.URL, &http.Cookie{Name: "client", Value: "2"}))
+}
@@ -0,0 +1,167 @@
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// blockedPrefixes are the networks blocked by default by a
+// DestinationPolicy: private, loopback, link-local (including cloud
+// metadata endpoints such as 169.254.169.254), shared, reserved, multicast
+// and IPv4-embedding IPv6 networks.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("::/96"),
+	netip.MustParsePrefix("::ffff:0:0/96"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// DestinationPolicy restricts the destinations of the requests of a client,
+// see WithDestinationPolicy.
+//
+// Allow and Deny use the patterns of ProxyRule.Match: host names and globs,
+// domains, CIDRs matched against the resolved addresses, optionally with a
+// port.
+type DestinationPolicy struct {
+	// Allow lists the destinations allowed even if their addresses are
+	// blocked by default, e.g. "10.1.0.0/16" or "*.corp.example.com".
+	Allow []string
+	// Deny lists additional blocked destinations. Deny takes precedence
+	// over Allow.
+	Deny []string
+}
+
+// DestinationError is returned when a request is sent to a destination not
+// allowed by the DestinationPolicy of the client.
+type DestinationError struct {
+	// Host is the host of the request.
+	Host string
+	// Addr is the blocked address of Host, if it was resolved.
+	Addr netip.Addr
+}
+
+// Error implements error.
+func (e *DestinationError) Error() string {
+	if e.Addr.IsValid() && e.Addr.String() != e.Host {
+		return fmt.Sprintf("httputil: destination %s (%s) is not allowed", e.Host, e.Addr)
+	}
+	return fmt.Sprintf("httputil: destination %s is not allowed", e.Host)
+}
+
+// destinationPolicy is a parsed DestinationPolicy.
+type destinationPolicy struct {
+	allow []proxyPattern
+	deny  []proxyPattern
+}
+
+// newDestinationPolicy parses policy.
+func newDestinationPolicy(policy *DestinationPolicy) (*destinationPolicy, error) {
+	p := &destinationPolicy{}
+	for _, patterns := range []struct {
+		src []string
+		dst *[]proxyPattern
+	}{{policy.Allow, &p.allow}, {policy.Deny, &p.deny}} {
+		for _, match := range patterns.src {
+			pattern, err := parseProxyPattern(match)
+			if err != nil {
+				return nil, fmt.Errorf("httputil: destination policy: %w", err)
+			}
+			*patterns.dst = append(*patterns.dst, pattern)
+		}
+	}
+	return p, nil
+}
+
+// check returns a *DestinationError if host, resolved to addr, is not an
+// allowed destination on port. addr is invalid when the host is resolved by
+// a proxy.
+func (p *destinationPolicy) check(host string, addr netip.Addr, port string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	addr = addr.WithZone("")
+	for _, pattern := range p.deny {
+		if pattern.match(host, addr, port) {
+			return &DestinationError{Host: host, Addr: addr}
+		}
+	}
+	for _, pattern := range p.allow {
+		if pattern.match(host, addr, port) {
+			return nil
+		}
+	}
+	if !addr.IsValid() {
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return &DestinationError{Host: host}
+		}
+		return nil
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return &DestinationError{Host: host, Addr: addr}
+		}
+	}
+	return nil
+}
+
+// proxyDialKey is the context key marking dials to a proxy.
+type proxyDialKey struct{}
+
+// isProxyDial reports whether a dial with ctx connects to a proxy rather
+// than to the destination of a request.
+func isProxyDial(ctx context.Context) bool {
+	if choice, ok := ctx.Value(proxyChoiceKey{}).(*proxyChoice); ok && choice.proxy != nil {
+		return true
+	}
+	return ctx.Value(proxyDialKey{}) != nil
+}
+
+// destinationTransport checks the destination of every request, including
+// every redirect, before it is sent. Host names are checked again with
+// their resolved addresses when dialing.
+type destinationTransport struct {
+	policy *destinationPolicy
+	next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *destinationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	// IPv4-mapped addresses are checked as they are, not unmapped
+	addr, _ := netip.ParseAddr(host)
+	if err := t.policy.check(host, addr, port); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transport.
+func (t *destinationTransport) CloseIdleConnections() {
+	closeIdleConnections(t.next)
+}
@@ -0,0 +1,108 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestinationPolicyCheck(t *testing.T) {
+	policy, err := newDestinationPolicy(&DestinationPolicy{})
+	assert.Nil(t, err)
+	for _, blocked := range []string{
+		"0.0.0.0", "10.1.2.3", "100.64.0.1", "127.0.0.1", "169.254.169.254", "172.31.255.255", "192.168.1.1",
+		"224.0.0.1", "255.255.255.255", "::", "::1", "::ffff:127.0.0.1", "::ffff:8.8.8.8", "64:ff9b::a00:1",
+		"fd00:ec2::254", "fe80::1%eth0", "ff02::1",
+	} {
+		assert.NotNil(t, policy.check(blocked, netip.MustParseAddr(blocked), "80"), blocked)
+	}
+	for _, allowed := range []string{"8.8.8.8", "172.32.0.1", "2606:4700:4700::1111"} {
+		assert.Nil(t, policy.check(allowed, netip.MustParseAddr(allowed), "80"), allowed)
+	}
+	assert.Nil(t, policy.check("example.com", netip.Addr{}, "443"))
+	assert.NotNil(t, policy.check("LOCALHOST.", netip.Addr{}, "443"))
+	assert.NotNil(t, policy.check("app.localhost", netip.Addr{}, "443"))
+
+	policy, err = newDestinationPolicy(&DestinationPolicy{
+		Allow: []string{"10.1.0.0/16", "*.corp.test", "192.168.0.1:8080"},
+		Deny:  []string{"10.1.2.0/24", "evil.test", ":25"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, policy.check("db.internal", netip.MustParseAddr("10.1.3.4"), "5432"))
+	assert.NotNil(t, policy.check("db.internal", netip.MustParseAddr("10.1.2.4"), "5432"))
+	assert.NotNil(t, policy.check("db.internal", netip.MustParseAddr("10.2.0.1"), "5432"))
+	assert.Nil(t, policy.check("api.corp.test", netip.MustParseAddr("127.0.0.1"), "80"))
+	assert.Nil(t, policy.check("192.168.0.1", netip.MustParseAddr("192.168.0.1"), "8080"))
+	assert.NotNil(t, policy.check("192.168.0.1", netip.MustParseAddr("192.168.0.1"), "80"))
+	assert.NotNil(t, policy.check("evil.test", netip.Addr{}, "80"))
+	assert.NotNil(t, policy.check("mail.example.com", netip.MustParseAddr("8.8.8.8"), "25"))
+
+	_, err = newDestinationPolicy(&DestinationPolicy{Allow: []string{"10.0.0.0/40"}})
+	assert.NotNil(t, err)
+}
+
+func TestWithDestinationPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.URL.Query().Get("redirect"); target != "" {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	port := u.Port()
+
+	c := NewClient(context.Background(), WithDestinationPolicy(DestinationPolicy{}))
+	_, err := c.Get(ts.URL)
+	var destErr *DestinationError
+	assert.ErrorAs(t, err, &destErr)
+	assert.Equal(t, "127.0.0.1", destErr.Host)
+
+	// checked after resolution
+	c = NewClient(context.Background(), WithDestinationPolicy(DestinationPolicy{}), WithHostOverrides(map[string]string{"rebind.test": "127.0.0.1"}))
+	_, err = c.Get("http://rebind.test:" + port + "/")
+	assert.ErrorAs(t, err, &destErr)
+	assert.Equal(t, "rebind.test", destErr.Host)
+	assert.Equal(t, netip.MustParseAddr("127.0.0.1"), destErr.Addr)
+
+	// allowed host, redirects checked again
+	c = NewClient(context.Background(), WithDestinationPolicy(DestinationPolicy{Allow: []string{"allowed.test"}}), WithHostOverrides(map[string]string{"allowed.test": "127.0.0.1"}))
+	resp, err := c.Get("http://allowed.test:" + port + "/")
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "ok", body)
+	for _, target := range []string{"http://127.0.0.1:" + port + "/", "http://[::ffff:127.0.0.1]:" + port + "/", "http://localhost:" + port + "/", "http://169.254.169.254/latest/meta-data/"} {
+		_, err = c.Get("http://allowed.test:" + port + "/?redirect=" + url.QueryEscape(target))
+		assert.ErrorAs(t, err, &destErr, target)
+	}
+
+	assert.NotNil(t, NewClient(context.Background(), WithDestinationPolicy(DestinationPolicy{Deny: []string{""}})).Err())
+}
+
+func TestDestinationPolicyEnvironmentProxy(t *testing.T) {
+	// http.ProxyFromEnvironment reads the environment once, so the client
+	// runs in a new test process
+	if os.Getenv("HTTPUTIL_TEST_ENV_PROXY") == "" {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("proxied " + r.URL.String()))
+		}))
+		defer proxy.Close()
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDestinationPolicyEnvironmentProxy$")
+		cmd.Env = append(os.Environ(), "HTTPUTIL_TEST_ENV_PROXY=1", "HTTP_PROXY="+proxy.URL, "http_proxy="+proxy.URL, "NO_PROXY=", "no_proxy=")
+		out, err := cmd.CombinedOutput()
+		assert.Nil(t, err, string(out))
+		return
+	}
+	resp, err := NewClient(context.Background(), WithDestinationPolicy(DestinationPolicy{})).Get("http://example.com/")
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "proxied http://example.com/", body)
+}
@@ -0,0 +1,55 @@
+package httputil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"Host":"` + r.Host + `","Path":"` + r.URL.Path + `"}]`))
+	}))
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := NewClient(context.Background(), WithUnixSocket(path)).Get("http://docker/v1.43/containers/json")
+	assert.Nil(t, err)
+	containers, err := ReadJSON[[]struct{ Host, Path string }](resp)
+	assert.Nil(t, err)
+	assert.Equal(t, []struct{ Host, Path string }{{"docker", "/v1.43/containers/json"}}, containers)
+}
+
+func TestDialContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+
+	var dialed []string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		var d net.Dialer
+		return d.DialContext(ctx, network, ts.Listener.Addr().String())
+	}
+	resp, err := NewClient(context.Background(), WithDialContext(dial)).Get("http://sidecar:8080/")
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "sidecar:8080", body)
+
+	// the dial function receives resolved addresses
+	resp, err = NewClient(context.Background(), WithDialContext(dial), WithHostOverrides(map[string]string{"sidecar": "192.0.2.1"})).Get("http://sidecar:8080/")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"sidecar:8080", "192.0.2.1:8080"}, dialed)
+}
@@ -0,0 +1,225 @@
+package httputil
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DigestAuth is an Authenticator implementing HTTP Digest authentication
+// (RFC 7616).
+//
+// The first request to a server is sent without credentials; the 401
+// challenge of the server is answered by sending the request again. The
+// challenge is remembered, so following requests are authenticated directly
+// with an increasing nonce count until the server sends a new nonce.
+//
+// The MD5, SHA-256 and SHA-512-256 algorithms and their -sess variants are
+// supported, with the quality of protection "auth" and "auth-int".
+type DigestAuth struct {
+	username string
+	password string
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint32
+}
+
+// digestChallenge is a parsed Digest challenge.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       []string
+	userhash  bool
+}
+
+// NewDigestAuth new Digest authenticator
+func NewDigestAuth(username, password string) *DigestAuth {
+	return &DigestAuth{username: username, password: password}
+}
+
+// Authenticate implements Authenticator.
+func (d *DigestAuth) Authenticate(req *http.Request) error {
+	d.mu.Lock()
+	challenge := d.challenge
+	if challenge == nil {
+		d.mu.Unlock()
+		return nil
+	}
+	d.nc++
+	nc := d.nc
+	d.mu.Unlock()
+	cnonce := make([]byte, 16)
+	if _, err := rand.Read(cnonce); err != nil {
+		return err
+	}
+	header, err := challenge.authorization(req, d.username, d.password, hex.EncodeToString(cnonce), nc)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", header)
+	return nil
+}
+
+// HandleChallenge implements ChallengeHandler.
+func (d *DigestAuth) HandleChallenge(resp *http.Response) (bool, error) {
+	var challenge *digestChallenge
+	stale := false
+	for _, c := range parseChallenges(resp.Header.Values("WWW-Authenticate")) {
+		if !strings.EqualFold(c.Scheme, "Digest") {
+			continue
+		}
+		algorithm := c.Params["algorithm"]
+		if algorithm == "" {
+			algorithm = "MD5"
+		}
+		if digestHash(algorithm) == nil {
+			continue
+		}
+		// prefer the strongest algorithm offered
+		if challenge != nil && digestStrength(algorithm) <= digestStrength(challenge.algorithm) {
+			continue
+		}
+		challenge = &digestChallenge{
+			realm:     c.Params["realm"],
+			nonce:     c.Params["nonce"],
+			opaque:    c.Params["opaque"],
+			algorithm: algorithm,
+			userhash:  strings.EqualFold(c.Params["userhash"], "true"),
+		}
+		for _, qop := range strings.Split(c.Params["qop"], ",") {
+			if qop = strings.TrimSpace(qop); qop != "" {
+				challenge.qop = append(challenge.qop, qop)
+			}
+		}
+		stale = strings.EqualFold(c.Params["stale"], "true")
+	}
+	if challenge == nil {
+		return false, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// credentials rejected for a nonce that is still fresh
+	sent := strings.HasPrefix(resp.Request.Header.Get("Authorization"), "Digest ")
+	if sent && !stale && d.challenge != nil && d.challenge.nonce == challenge.nonce {
+		return false, nil
+	}
+	d.challenge = challenge
+	d.nc = 0
+	return true, nil
+}
+
+// authorization returns the Authorization header answering c for req.
+func (c *digestChallenge) authorization(req *http.Request, username, password, cnonce string, nc uint32) (string, error) {
+	newHash := digestHash(c.algorithm)
+	h := func(s string) string {
+		hasher := newHash()
+		io.WriteString(hasher, s)
+		return hex.EncodeToString(hasher.Sum(nil))
+	}
+	qop := ""
+	for _, offered := range c.qop {
+		if offered == "auth" || offered == "auth-int" && qop == "" {
+			qop = offered
+		}
+	}
+	if qop == "" && len(c.qop) > 0 {
+		return "", fmt.Errorf("httputil: digest: unsupported qop %q", strings.Join(c.qop, ","))
+	}
+	uri := req.URL.RequestURI()
+	a1 := h(username + ":" + c.realm + ":" + password)
+	if strings.HasSuffix(strings.ToLower(c.algorithm), "-sess") {
+		a1 = h(a1 + ":" + c.nonce + ":" + cnonce)
+	}
+	a2 := req.Method + ":" + uri
+	if qop == "auth-int" {
+		bodyHash, err := digestBodyHash(req, newHash)
+		if err != nil {
+			return "", err
+		}
+		a2 += ":" + bodyHash
+	}
+	ncValue := fmt.Sprintf("%08x", nc)
+	var response string
+	if qop == "" {
+		response = h(a1 + ":" + c.nonce + ":" + h(a2))
+	} else {
+		response = h(a1 + ":" + c.nonce + ":" + ncValue + ":" + cnonce + ":" + qop + ":" + h(a2))
+	}
+	if c.userhash {
+		username = h(username + ":" + c.realm)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s, algorithm=%s, response=%s`,
+		quoteString(username), quoteString(c.realm), quoteString(c.nonce), quoteString(uri), c.algorithm, quoteString(response))
+	if c.opaque != "" {
+		fmt.Fprintf(&b, `, opaque=%s`, quoteString(c.opaque))
+	}
+	if qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce=%s`, qop, ncValue, quoteString(cnonce))
+	}
+	if c.userhash {
+		b.WriteString(", userhash=true")
+	}
+	return b.String(), nil
+}
+
+// digestBodyHash returns the hash of the body of req without consuming it.
+func digestBodyHash(req *http.Request, newHash func() hash.Hash) (string, error) {
+	hasher := newHash()
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", errors.New("httputil: digest: auth-int requires a replayable request body")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err = io.Copy(hasher, body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// digestHash returns the hash function of a Digest algorithm, or nil if the
+// algorithm is not supported.
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	case "SHA-512-256":
+		return sha512.New512_256
+	}
+	return nil
+}
+
+// digestStrength ranks Digest algorithms by preference.
+func digestStrength(algorithm string) int {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "SHA-512-256":
+		return 3
+	case "SHA-256":
+		return 2
+	}
+	return 1
+}
+
+// quoteString returns s as a quoted-string.
+func quoteString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,76 @@
+package httputil
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigestAuthorization(t *testing.T) {
+	// RFC 7616 section 3.9.1
+	req, err := http.NewRequest(http.MethodGet, "http://www.example.org/dir/index.html", nil)
+	assert.Nil(t, err)
+	for algorithm, want := range map[string]string{
+		"MD5":     "8ca523f5e9506fed4657c9700eebdbec",
+		"SHA-256": "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1",
+	} {
+		c := &digestChallenge{
+			realm:     "http-auth@example.org",
+			nonce:     "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+			opaque:    "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+			algorithm: algorithm,
+			qop:       []string{"auth", "auth-int"},
+		}
+		header, err := c.authorization(req, "Mufasa", "Circle of Life", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", 1)
+		assert.Nil(t, err)
+		assert.Contains(t, header, `response="`+want+`"`)
+		assert.Contains(t, header, "nc=00000001")
+		assert.Contains(t, header, `opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`)
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	const nonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+	var ncs []string
+	h := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		challenges := parseChallenges(r.Header.Values("Authorization"))
+		if len(challenges) == 0 {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="test", qop="auth-int", nonce="%s", algorithm=MD5-sess`, nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p := challenges[0].Params
+		a1 := h(h("user:test:pass") + ":" + nonce + ":" + p["cnonce"])
+		a2 := h(r.Method + ":" + p["uri"] + ":" + h(string(body)))
+		if p["response"] != h(a1+":"+nonce+":"+p["nc"]+":"+p["cnonce"]+":"+p["qop"]+":"+a2) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		ncs = append(ncs, p["nc"])
+		w.Write(body)
+	}))
+	defer ts.Close()
+	c := NewClient(context.Background(), WithDigestAuth("user", "pass"))
+	for _, payload := range []string{"first", "second"} {
+		resp, err := c.Post(ts.URL+"/upload?x=1", "text/plain", strings.NewReader(payload))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := ReadString(resp)
+		assert.Nil(t, err)
+		assert.Equal(t, payload, body)
+	}
+	assert.Equal(t, []string{"00000001", "00000002"}, ncs)
+}
@@ -0,0 +1,247 @@
+package httputil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsTimeout bounds a query to a DNS server.
+const dnsTimeout = 5 * time.Second
+
+// dnsClient queries DNS servers directly, so that the TTLs of the records
+// are known to the DNS cache.
+type dnsClient struct {
+	servers []string
+}
+
+// newDNSClient returns a dnsClient querying servers in order. Servers
+// without port use port 53.
+func newDNSClient(servers []string) (*dnsClient, error) {
+	c := &dnsClient{}
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		if _, err := netip.ParseAddrPort(server); err != nil {
+			return nil, fmt.Errorf("httputil: invalid DNS server %q: %w", server, err)
+		}
+		c.servers = append(c.servers, server)
+	}
+	return c, nil
+}
+
+// lookup resolves the A and AAAA records of host.
+func (c *dnsClient) lookup(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	return lookupAddrs(ctx, host, func(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+		answer, err := c.query(ctx, name, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		return parseDNSAnswer(answer, host)
+	})
+}
+
+// dnsQueryFunc returns the addresses of the records of name of type qtype
+// and their TTL, or a not found *net.DNSError with the negative caching TTL.
+type dnsQueryFunc func(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]netip.Addr, time.Duration, error)
+
+// lookupAddrs resolves the A and AAAA records of host with query. The TTL is
+// the lowest TTL of the answers, or the negative caching TTL of the zone
+// (RFC 2308) when host has no address.
+func lookupAddrs(ctx context.Context, host string, query dnsQueryFunc) ([]netip.Addr, time.Duration, error) {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		// RFC 6761 section 6.3
+		return []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.IPv6Loopback()}, -1, nil
+	}
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	var addrs []netip.Addr
+	ttl := time.Duration(-1)
+	notFound := true
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		result, resultTTL, err := query(ctx, name, qtype)
+		if err != nil {
+			var dnsErr *net.DNSError
+			if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+				return nil, 0, err
+			}
+		} else {
+			notFound = false
+			addrs = append(addrs, result...)
+		}
+		if ttl < 0 || (resultTTL >= 0 && resultTTL < ttl) {
+			ttl = resultTTL
+		}
+	}
+	if notFound {
+		return nil, ttl, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, ttl, nil
+}
+
+// query sends a query to the servers in order until one answers.
+func (c *dnsClient) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	var lastErr error
+	for _, server := range c.servers {
+		answer, err := c.exchange(ctx, server, name, qtype)
+		if err == nil {
+			return answer, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, &net.DNSError{Err: lastErr.Error(), Name: strings.TrimSuffix(name.String(), "."), Server: c.servers[len(c.servers)-1], IsTimeout: isTimeout(lastErr)}
+}
+
+// exchange sends a query to server over UDP, retrying over TCP when the
+// answer is truncated.
+func (c *dnsClient) exchange(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+	id := uint16(rand.Uint32())
+	query, err := newDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	answer, err := dnsExchange(ctx, "udp", server, query)
+	if err == nil && answer.Truncated {
+		answer, err = dnsExchange(ctx, "tcp", server, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if answer.ID != id || !answer.Response || len(answer.Questions) != 1 ||
+		answer.Questions[0].Type != qtype || !strings.EqualFold(answer.Questions[0].Name.String(), name.String()) {
+		return nil, errors.New("invalid DNS response")
+	}
+	return answer, nil
+}
+
+// newDNSQuery returns a recursive query for the records of name.
+func newDNSQuery(id uint16, name dnsmessage.Name, qtype dnsmessage.Type) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 2, 514), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	// EDNS(0) to allow UDP answers larger than 512 bytes
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// dnsExchange sends query, prefixed with room for its TCP length, to server
+// and returns the answer.
+func dnsExchange(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	var data []byte
+	if network == "tcp" {
+		binary.BigEndian.PutUint16(query, uint16(len(query)-2))
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		data = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query[2:]); err != nil {
+			return nil, err
+		}
+		data = make([]byte, 65535)
+		n, err := conn.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[:n]
+	}
+	var answer dnsmessage.Message
+	if err := answer.Unpack(data); err != nil {
+		return nil, err
+	}
+	return &answer, nil
+}
+
+// parseDNSAnswer returns the addresses of an answer and its TTL.
+func parseDNSAnswer(answer *dnsmessage.Message, host string) ([]netip.Addr, time.Duration, error) {
+	switch answer.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return nil, 0, &net.DNSError{Err: "server misbehaving: " + answer.RCode.String(), Name: host, IsTemporary: answer.RCode == dnsmessage.RCodeServerFailure}
+	}
+	var addrs []netip.Addr
+	ttl := time.Duration(-1)
+	minTTL := func(seconds uint32) {
+		if d := time.Duration(seconds) * time.Second; ttl < 0 || d < ttl {
+			ttl = d
+		}
+	}
+	if answer.RCode == dnsmessage.RCodeSuccess {
+		for _, record := range answer.Answers {
+			switch body := record.Body.(type) {
+			case *dnsmessage.AResource:
+				addrs = append(addrs, netip.AddrFrom4(body.A))
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, netip.AddrFrom16(body.AAAA).Unmap())
+			case *dnsmessage.CNAMEResource:
+			default:
+				continue
+			}
+			minTTL(record.Header.TTL)
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, ttl, nil
+	}
+	ttl = -1
+	for _, record := range answer.Authorities {
+		if soa, ok := record.Body.(*dnsmessage.SOAResource); ok {
+			minTTL(min(record.Header.TTL, soa.MinTTL))
+		}
+	}
+	return nil, ttl, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// isTimeout reports whether err is a timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
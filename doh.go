@@ -0,0 +1,213 @@
+package httputil
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DoHConfig configures DNS-over-HTTPS resolution, see WithDoH.
+type DoHConfig struct {
+	// URL is the DoH endpoint, e.g. "https://cloudflare-dns.com/dns-query".
+	URL string
+	// JSON uses the JSON API ("application/dns-json") of the endpoint
+	// instead of the RFC 8484 wire format.
+	JSON bool
+	// Bootstrap are the IP addresses of the endpoint host, so that it is
+	// not resolved through the system resolver.
+	Bootstrap []string
+	// DisableFallback disables resolving host names with the system
+	// resolver, or the resolver of WithResolver, when the endpoint fails.
+	DisableFallback bool
+	// HTTPClient is used to call the endpoint. Defaults to a client
+	// connecting to the Bootstrap addresses.
+	HTTPClient *http.Client
+}
+
+// dohClient resolves host names with DNS-over-HTTPS.
+type dohClient struct {
+	endpoint *url.URL
+	json     bool
+	client   *http.Client
+}
+
+// newDoHClient returns the dohClient of config.
+func newDoHClient(config *DoHConfig) (*dohClient, error) {
+	endpoint, err := url.Parse(config.URL)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("httputil: invalid DoH URL %q", config.URL)
+	}
+	c := &dohClient{endpoint: endpoint, json: config.JSON, client: config.HTTPClient}
+	if c.client != nil {
+		return c, nil
+	}
+	transport := cloneDefaultTransport()
+	if len(config.Bootstrap) > 0 {
+		var addrs []netip.Addr
+		for _, ip := range config.Bootstrap {
+			addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+			if err != nil {
+				return nil, fmt.Errorf("httputil: invalid DoH bootstrap address: %w", err)
+			}
+			addrs = append(addrs, addr.Unmap())
+		}
+		var dialer net.Dialer
+		host := endpoint.Hostname()
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			h, port, err := net.SplitHostPort(addr)
+			if err != nil || !strings.EqualFold(h, host) {
+				return dialer.DialContext(ctx, network, addr)
+			}
+			return dialAddrs(ctx, dialer.DialContext, network, addrs, port, 0)
+		}
+	}
+	c.client = &http.Client{Transport: transport, Timeout: dnsTimeout}
+	return c, nil
+}
+
+// lookup resolves the A and AAAA records of host.
+func (c *dohClient) lookup(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+	return lookupAddrs(ctx, host, func(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+		if c.json {
+			return c.queryJSON(ctx, host, name, qtype)
+		}
+		return c.queryWire(ctx, host, name, qtype)
+	})
+}
+
+// queryWire sends an RFC 8484 GET query.
+func (c *dohClient) queryWire(ctx context.Context, host string, name dnsmessage.Name, qtype dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+	// ID 0 makes the query cacheable by HTTP caches (RFC 8484 section 4.1)
+	query, err := newDNSQuery(0, name, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := c.get(ctx, host, url.Values{"dns": {base64.RawURLEncoding.EncodeToString(query[2:])}}, "application/dns-message")
+	if err != nil {
+		return nil, 0, err
+	}
+	var answer dnsmessage.Message
+	if err := answer.Unpack(data); err != nil {
+		return nil, 0, c.error(host, err)
+	}
+	return parseDNSAnswer(&answer, host)
+}
+
+// dohJSONResponse is a response of the JSON API.
+type dohJSONResponse struct {
+	Status    int             `json:"Status"`
+	Answer    []dohJSONRecord `json:"Answer"`
+	Authority []dohJSONRecord `json:"Authority"`
+}
+
+// dohJSONRecord is a resource record of the JSON API.
+type dohJSONRecord struct {
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// queryJSON sends a query to the JSON API.
+func (c *dohClient) queryJSON(ctx context.Context, host string, name dnsmessage.Name, qtype dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+	data, err := c.get(ctx, host, url.Values{"name": {name.String()}, "type": {strconv.Itoa(int(qtype))}}, "application/dns-json")
+	if err != nil {
+		return nil, 0, err
+	}
+	var resp dohJSONResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, 0, c.error(host, err)
+	}
+	answer := &dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCode(resp.Status)}}
+	for _, record := range resp.Answer {
+		header := dnsmessage.ResourceHeader{Type: dnsmessage.Type(record.Type), TTL: record.TTL}
+		switch header.Type {
+		case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+			addr, err := netip.ParseAddr(record.Data)
+			if err != nil {
+				continue
+			}
+			if header.Type == dnsmessage.TypeA && addr.Is4() {
+				answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: addr.As4()}})
+			} else if header.Type == dnsmessage.TypeAAAA {
+				answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}})
+			}
+		case dnsmessage.TypeCNAME:
+			answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.CNAMEResource{}})
+		}
+	}
+	for _, record := range resp.Authority {
+		// "mname rname serial refresh retry expire minimum"
+		fields := strings.Fields(record.Data)
+		if record.Type != uint16(dnsmessage.TypeSOA) || len(fields) != 7 {
+			continue
+		}
+		minTTL, err := strconv.ParseUint(fields[6], 10, 32)
+		if err != nil {
+			continue
+		}
+		answer.Authorities = append(answer.Authorities, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeSOA, TTL: record.TTL},
+			Body:   &dnsmessage.SOAResource{MinTTL: uint32(minTTL)},
+		})
+	}
+	return parseDNSAnswer(answer, host)
+}
+
+// get sends a GET request with query to the endpoint and returns the body
+// of the response.
+func (c *dohClient) get(ctx context.Context, host string, query url.Values, accept string) ([]byte, error) {
+	u := *c.endpoint
+	params := u.Query()
+	for key, values := range query {
+		params[key] = values
+	}
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, c.error(host, err)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, c.error(host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.error(host, fmt.Errorf("DoH query: %s", resp.Status))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, c.error(host, err)
+	}
+	return data, nil
+}
+
+// error returns a *net.DNSError for a failed query of host.
+func (c *dohClient) error(host string, err error) error {
+	return &net.DNSError{Err: err.Error(), Name: host, Server: c.endpoint.Host, IsTimeout: isTimeout(err)}
+}
+
+// fallbackLookup returns a lookupFunc resolving host names with lookup, and
+// with fallback when lookup fails for another reason than host not found.
+func fallbackLookup(lookup, fallback lookupFunc) lookupFunc {
+	return func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		addrs, ttl, err := lookup(ctx, host)
+		var dnsErr *net.DNSError
+		if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) || ctx.Err() != nil {
+			return addrs, ttl, err
+		}
+		return fallback(ctx, host)
+	}
+}
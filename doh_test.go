@@ -0,0 +1,155 @@
+package httputil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDoHHandler serves the answers of testDNSAnswer in the RFC 8484 wire
+// format and as JSON.
+func testDoHHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == "application/dns-message" {
+			query, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+			assert.Nil(t, err)
+			w.Header().Set("Content-Type", "application/dns-message")
+			w.Write(testDNSAnswer(query, true))
+			return
+		}
+		qtype, _ := strconv.Atoi(r.URL.Query().Get("type"))
+		query, _ := newDNSQuery(0, dnsmessage.MustNewName(r.URL.Query().Get("name")), dnsmessage.Type(qtype))
+		var answer dnsmessage.Message
+		assert.Nil(t, answer.Unpack(testDNSAnswer(query[2:], true)))
+		resp := dohJSONResponse{Status: int(answer.RCode)}
+		for _, record := range answer.Answers {
+			var data string
+			switch body := record.Body.(type) {
+			case *dnsmessage.AResource:
+				data = netip.AddrFrom4(body.A).String()
+			case *dnsmessage.AAAAResource:
+				data = netip.AddrFrom16(body.AAAA).String()
+			}
+			resp.Answer = append(resp.Answer, dohJSONRecord{Type: uint16(record.Header.Type), TTL: record.Header.TTL, Data: data})
+		}
+		for _, record := range answer.Authorities {
+			soa := record.Body.(*dnsmessage.SOAResource)
+			data := soa.NS.String() + " " + soa.MBox.String() + " 1 7200 3600 1209600 " + strconv.Itoa(int(soa.MinTTL))
+			resp.Authority = append(resp.Authority, dohJSONRecord{Type: uint16(dnsmessage.TypeSOA), TTL: record.Header.TTL, Data: data})
+		}
+		w.Header().Set("Content-Type", "application/dns-json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+func TestDoH(t *testing.T) {
+	ca, server, _ := newTestPKI(t)
+	ts := httptest.NewUnstartedServer(testDoHHandler(t))
+	cert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	assert.Nil(t, err)
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.StartTLS()
+	defer ts.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	u, _ := url.Parse(ts.URL)
+
+	for _, jsonAPI := range []bool{false, true} {
+		// the endpoint host is only known through the bootstrap address
+		client, err := newDoHClient(&DoHConfig{URL: "https://example.test:" + u.Port() + "/dns-query", JSON: jsonAPI, Bootstrap: []string{"127.0.0.1"}})
+		assert.Nil(t, err)
+		client.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: pool}
+
+		addrs, ttl, err := client.lookup(context.Background(), "a.test")
+		assert.Nil(t, err, jsonAPI)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, addrs)
+		assert.Equal(t, 30*time.Second, ttl)
+
+		addrs, ttl, err = client.lookup(context.Background(), "big.test")
+		assert.Nil(t, err)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("::1")}, addrs)
+		assert.Equal(t, 10*time.Second, ttl)
+
+		_, ttl, err = client.lookup(context.Background(), "missing.test")
+		var dnsErr *net.DNSError
+		assert.ErrorAs(t, err, &dnsErr)
+		assert.True(t, dnsErr.IsNotFound)
+		assert.Equal(t, 120*time.Second, ttl)
+	}
+
+	_, err = newDoHClient(&DoHConfig{URL: "http://dns.test/dns-query"})
+	assert.NotNil(t, err)
+	_, err = newDoHClient(&DoHConfig{URL: "https://dns.test/dns-query", Bootstrap: []string{"dns.test"}})
+	assert.NotNil(t, err)
+}
+
+func TestWithDoH(t *testing.T) {
+	doh := httptest.NewTLSServer(testDoHHandler(t))
+	defer doh.Close()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+	u, _ := url.Parse(target.URL)
+	u.Host = "a.test:" + u.Port()
+
+	resp, err := NewClient(context.Background(), WithDoH(DoHConfig{URL: doh.URL + "/dns-query", HTTPClient: doh.Client()})).Get(u.String())
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "ok", body)
+
+	// failing endpoint
+	failing := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	_, err = NewClient(context.Background(), WithDoH(DoHConfig{URL: failing.URL, HTTPClient: failing.Client(), DisableFallback: true})).Get(u.String())
+	var dnsErr *net.DNSError
+	assert.ErrorAs(t, err, &dnsErr)
+	assert.Contains(t, dnsErr.Err, "500")
+
+	assert.NotNil(t, NewClient(context.Background(), WithDoH(DoHConfig{URL: "dns.test"})).Err())
+}
+
+func TestFallbackLookup(t *testing.T) {
+	fallbackAddrs := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+	fallback := func(context.Context, string) ([]netip.Addr, time.Duration, error) {
+		return fallbackAddrs, -1, nil
+	}
+	failing := func(context.Context, string) ([]netip.Addr, time.Duration, error) {
+		return nil, 0, &net.DNSError{Err: "DoH query: 500 Internal Server Error"}
+	}
+	notFound := func(context.Context, string) ([]netip.Addr, time.Duration, error) {
+		return nil, time.Minute, &net.DNSError{Err: "no such host", IsNotFound: true}
+	}
+
+	addrs, ttl, err := fallbackLookup(failing, fallback)(context.Background(), "a.test")
+	assert.Nil(t, err)
+	assert.Equal(t, fallbackAddrs, addrs)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	_, ttl, err = fallbackLookup(notFound, fallback)(context.Background(), "a.test")
+	assert.NotNil(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = fallbackLookup(func(ctx context.Context, _ string) ([]netip.Addr, time.Duration, error) {
+		return nil, 0, ctx.Err()
+	}, fallback)(ctx, "a.test")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
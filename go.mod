@@ -3,8 +3,10 @@ module github.com/gofika/httputil
 go 1.25.0
 
 require (
+	github.com/dop251/goja v0.0.0-20260917113740-793a2a65c13b
 	github.com/gofika/fileutil v0.0.0-20240604055302-e4cb1b6868db
 	github.com/gofika/regexputil v0.0.0-20240604070104-a95e993fd7d7
+	github.com/klauspost/compress v1.18.0
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/net v0.56.0
 	golang.org/x/text v0.38.0
@@ -12,6 +14,9 @@ require (
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dlclark/regexp2/v2 v2.5.2 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,204 @@
+package httputil
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrHMACSignatureMissing is returned when a request carries no signature or timestamp.
+	ErrHMACSignatureMissing = errors.New("httputil: hmac: missing signature")
+	// ErrHMACSignatureMismatch is returned when a signature does not match the request.
+	ErrHMACSignatureMismatch = errors.New("httputil: hmac: signature mismatch")
+	// ErrHMACTimestampExpired is returned when a timestamp is outside the tolerance.
+	ErrHMACTimestampExpired = errors.New("httputil: hmac: timestamp outside tolerance")
+)
+
+// HMACEncoding is the text encoding of an HMAC signature.
+type HMACEncoding int
+
+const (
+	// HMACHex encodes signatures as lower-case hex.
+	HMACHex HMACEncoding = iota
+	// HMACBase64 encodes signatures as standard base64.
+	HMACBase64
+)
+
+// HMACSigner signs requests with an HMAC of the request timestamp, method,
+// path and body hash, as used by many partner APIs and webhooks.
+//
+// By default the signed message is
+//
+//	timestamp + "\n" + method + "\n" + path?query + "\n" + hex(sha256(body))
+//
+// the timestamp is sent in Unix seconds in the X-Timestamp header and the
+// hex HMAC-SHA256 signature in the X-Signature header.
+type HMACSigner struct {
+	// Key is the shared secret.
+	Key []byte
+	// Hash is the hash of the HMAC. Defaults to sha256.New.
+	Hash func() hash.Hash
+	// SignatureHeader receives the signature. Defaults to "X-Signature".
+	SignatureHeader string
+	// TimestampHeader receives the timestamp. Defaults to "X-Timestamp".
+	TimestampHeader string
+	// Prefix is prepended to the encoded signature, e.g. "sha256=".
+	Prefix string
+	// Encoding is the encoding of the signature.
+	Encoding HMACEncoding
+	// Message builds the signed message. Defaults to the message described above.
+	Message func(timestamp, method, path, bodyHash string) string
+	// MaxBodySize limits the body read by VerifyHMACSignature. Defaults to
+	// 10 MB.
+	MaxBodySize int64
+
+	now func() time.Time
+}
+
+// Middleware returns a Middleware signing every request with s.
+func (s *HMACSigner) Middleware() Middleware {
+	return SignerMiddleware(s.Sign)
+}
+
+// Sign signs req by setting its timestamp and signature headers.
+func (s *HMACSigner) Sign(req *http.Request) error {
+	bodyHash, err := bodySHA256(req)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(s.time().Unix(), 10)
+	req.Header.Set(s.timestampHeader(), timestamp)
+	req.Header.Set(s.signatureHeader(), s.Prefix+s.encode(s.sign(timestamp, req.Method, req.URL.RequestURI(), bodyHash)))
+	return nil
+}
+
+// VerifyHMACSignature verifies the signature of an incoming request signed
+// as configured by signer, e.g. a webhook. The timestamp must be within
+// tolerance of the current time; a tolerance of 0 disables the check.
+//
+// The body of r is read and replaced, so it can still be read afterwards. A
+// body larger than signer.MaxBodySize is rejected with an
+// *http.MaxBytesError.
+func VerifyHMACSignature(r *http.Request, signer *HMACSigner, tolerance time.Duration) error {
+	timestamp := r.Header.Get(signer.timestampHeader())
+	signature := r.Header.Get(signer.signatureHeader())
+	if timestamp == "" || signature == "" || !strings.HasPrefix(signature, signer.Prefix) {
+		return ErrHMACSignatureMissing
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrHMACSignatureMismatch
+	}
+	if tolerance > 0 {
+		if age := signer.time().Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+			return ErrHMACTimestampExpired
+		}
+	}
+	got, err := signer.decode(strings.TrimPrefix(signature, signer.Prefix))
+	if err != nil {
+		return ErrHMACSignatureMismatch
+	}
+	body, err := readBodyLimit(r, signer.MaxBodySize)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	want := signer.sign(timestamp, r.Method, r.URL.RequestURI(), hex.EncodeToString(sum[:]))
+	if !hmac.Equal(got, want) {
+		return ErrHMACSignatureMismatch
+	}
+	return nil
+}
+
+// defaultMaxBodySize is the default size limit of the bodies read to verify
+// signatures.
+const defaultMaxBodySize = 10 << 20
+
+// readBodyLimit reads the body of the incoming request r, up to limit bytes
+// or defaultMaxBodySize if limit is 0, and replaces it so that it can still
+// be read afterwards.
+func readBodyLimit(r *http.Request, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+	if r.Body == nil {
+		return nil, nil
+	}
+	if r.ContentLength > limit {
+		return nil, &http.MaxBytesError{Limit: limit}
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body.Close()
+	if err == nil && int64(len(body)) > limit {
+		body, err = body[:limit], &http.MaxBytesError{Limit: limit}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+// sign returns the raw signature of a request.
+func (s *HMACSigner) sign(timestamp, method, path, bodyHash string) []byte {
+	var message string
+	if s.Message != nil {
+		message = s.Message(timestamp, method, path, bodyHash)
+	} else {
+		message = timestamp + "\n" + method + "\n" + path + "\n" + bodyHash
+	}
+	newHash := s.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	mac := hmac.New(newHash, s.Key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// encode encodes a raw signature.
+func (s *HMACSigner) encode(signature []byte) string {
+	if s.Encoding == HMACBase64 {
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+	return hex.EncodeToString(signature)
+}
+
+// decode decodes an encoded signature.
+func (s *HMACSigner) decode(signature string) ([]byte, error) {
+	if s.Encoding == HMACBase64 {
+		return base64.StdEncoding.DecodeString(signature)
+	}
+	return hex.DecodeString(signature)
+}
+
+// signatureHeader returns the name of the signature header.
+func (s *HMACSigner) signatureHeader() string {
+	if s.SignatureHeader != "" {
+		return s.SignatureHeader
+	}
+	return "X-Signature"
+}
+
+// timestampHeader returns the name of the timestamp header.
+func (s *HMACSigner) timestampHeader() string {
+	if s.TimestampHeader != "" {
+		return s.TimestampHeader
+	}
+	return "X-Timestamp"
+}
+
+// time returns the current time.
+func (s *HMACSigner) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,61 @@
+package httputil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACSigner(t *testing.T) {
+	signer := &HMACSigner{Key: []byte("secret"), Prefix: "sha256=", Encoding: HMACBase64}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := VerifyHMACSignature(r, signer, 5*time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+	c := NewClient(context.Background(), WithMiddleware(signer.Middleware()))
+	resp, err := c.Post(ts.URL+"/hook?id=1", "text/plain", strings.NewReader("payload"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ReadString(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "payload", body)
+}
+
+func TestVerifyHMACSignature(t *testing.T) {
+	signer := &HMACSigner{Key: []byte("secret")}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader("payload"))
+		assert.Nil(t, signer.Sign(req))
+		return req
+	}
+	assert.Nil(t, VerifyHMACSignature(newRequest(), signer, time.Minute))
+
+	req := newRequest()
+	req.Body = io.NopCloser(strings.NewReader("tampered"))
+	assert.ErrorIs(t, VerifyHMACSignature(req, signer, time.Minute), ErrHMACSignatureMismatch)
+
+	req = newRequest()
+	req.Header.Del("X-Signature")
+	assert.ErrorIs(t, VerifyHMACSignature(req, signer, time.Minute), ErrHMACSignatureMissing)
+
+	verifier := &HMACSigner{Key: []byte("secret"), now: func() time.Time { return time.Now().Add(time.Hour) }}
+	assert.ErrorIs(t, VerifyHMACSignature(newRequest(), verifier, time.Minute), ErrHMACTimestampExpired)
+
+	var tooLarge *http.MaxBytesError
+	limited := &HMACSigner{Key: []byte("secret"), MaxBodySize: 4}
+	assert.ErrorAs(t, VerifyHMACSignature(newRequest(), limited, time.Minute), &tooLarge)
+	req = newRequest()
+	req.ContentLength = -1
+	assert.ErrorAs(t, VerifyHMACSignature(req, limited, time.Minute), &tooLarge)
+	assert.Equal(t, int64(4), tooLarge.Limit)
+}
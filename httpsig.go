@@ -0,0 +1,496 @@
+package httputil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP message signature algorithms (RFC 9421 section 3.3).
+const (
+	MessageSigEd25519         = "ed25519"
+	MessageSigECDSAP256SHA256 = "ecdsa-p256-sha256"
+	MessageSigRSAPSSSHA512    = "rsa-pss-sha512"
+	MessageSigHMACSHA256      = "hmac-sha256"
+)
+
+var (
+	// ErrMessageSignatureMissing is returned when a request carries no matching message signature.
+	ErrMessageSignatureMissing = errors.New("httputil: httpsig: missing signature")
+	// ErrMessageSignatureInvalid is returned when a message signature does not verify.
+	ErrMessageSignatureInvalid = errors.New("httputil: httpsig: invalid signature")
+)
+
+// MessageSigner signs requests with HTTP Message Signatures (RFC 9421),
+// setting the Signature-Input and Signature headers.
+type MessageSigner struct {
+	// Label of the signature. Defaults to "sig1".
+	Label string
+	// KeyID is sent as the "keyid" parameter.
+	KeyID string
+	// Algorithm is one of the MessageSig* algorithms.
+	Algorithm string
+	// Key is the signing key: an ed25519.PrivateKey, *ecdsa.PrivateKey,
+	// *rsa.PrivateKey or, for hmac-sha256, the shared secret as []byte.
+	Key any
+	// Components are the covered component identifiers: derived components
+	// such as "@method", "@authority", "@path", "@query", "@target-uri" and
+	// "@scheme", or lower-case header names. Defaults to "@method",
+	// "@authority", "@path", "@query", plus "content-type" and
+	// "content-digest" for requests with a body.
+	//
+	// When "content-digest" is covered, a sha-256 Content-Digest header
+	// (RFC 9530) is added if the request has none.
+	Components []string
+	// Expires sets the "expires" parameter relative to the creation time.
+	Expires time.Duration
+	// IncludeAlg sends the "alg" parameter.
+	IncludeAlg bool
+	// Tag is sent as the "tag" parameter.
+	Tag string
+
+	now func() time.Time
+}
+
+// Middleware returns a Middleware signing every request with s.
+func (s *MessageSigner) Middleware() Middleware {
+	return SignerMiddleware(s.Sign)
+}
+
+// Sign signs req.
+func (s *MessageSigner) Sign(req *http.Request) error {
+	components := s.Components
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if len(components) == 0 {
+		components = []string{"@method", "@authority", "@path", "@query"}
+		if hasBody {
+			if req.Header.Get("Content-Type") != "" {
+				components = append(components, "content-type")
+			}
+			components = append(components, "content-digest")
+		}
+	}
+	for _, component := range components {
+		if component == "content-digest" && req.Header.Get("Content-Digest") == "" {
+			bodyHash, err := bodySHA256(req)
+			if err != nil {
+				return err
+			}
+			sum, _ := hex.DecodeString(bodyHash)
+			req.Header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+		}
+	}
+	created := time.Now()
+	if s.now != nil {
+		created = s.now()
+	}
+	params := messageSigParams{components: components, created: created.Unix(), keyID: s.KeyID, tag: s.Tag}
+	if s.Expires > 0 {
+		params.expires = created.Add(s.Expires).Unix()
+	}
+	if s.IncludeAlg {
+		params.alg = s.Algorithm
+	}
+	base, err := messageSignatureBase(req, components, params.String())
+	if err != nil {
+		return err
+	}
+	signature, err := messageSign(s.Algorithm, s.Key, base)
+	if err != nil {
+		return err
+	}
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	req.Header.Add("Signature-Input", label+"="+params.String())
+	req.Header.Add("Signature", label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// MessageVerifier verifies HTTP Message Signatures (RFC 9421).
+type MessageVerifier struct {
+	// Keys returns the algorithm and the verification key of keyID: an
+	// ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey or, for
+	// hmac-sha256, the shared secret as []byte.
+	Keys func(keyID string) (algorithm string, key any, err error)
+	// Label selects the signature to verify. Defaults to the first signature.
+	Label string
+	// RequiredComponents must be covered by the signature.
+	RequiredComponents []string
+	// MaxAge rejects signatures created longer ago. 0 disables the check.
+	MaxAge time.Duration
+	// MaxBodySize limits the body read to check the Content-Digest header.
+	// Defaults to 10 MB.
+	MaxBodySize int64
+}
+
+// VerifyMessageSignature verifies the message signature of an incoming
+// request. When "content-digest" is covered, the Content-Digest header is
+// checked against the body, which is read and replaced so it can still be
+// read afterwards. A body larger than verifier.MaxBodySize is rejected with
+// an *http.MaxBytesError.
+func VerifyMessageSignature(r *http.Request, verifier *MessageVerifier) error {
+	inputs := parseSFDictionary(strings.Join(r.Header.Values("Signature-Input"), ", "))
+	signatures := parseSFDictionary(strings.Join(r.Header.Values("Signature"), ", "))
+	var input, signature *sfMember
+	for i := range inputs {
+		if verifier.Label == "" || inputs[i].name == verifier.Label {
+			input = &inputs[i]
+			break
+		}
+	}
+	if input != nil {
+		for i := range signatures {
+			if signatures[i].name == input.name {
+				signature = &signatures[i]
+			}
+		}
+	}
+	if input == nil || signature == nil || !input.innerList || signature.bytes == nil {
+		return ErrMessageSignatureMissing
+	}
+	for _, required := range verifier.RequiredComponents {
+		if !slices.Contains(input.items, required) {
+			return fmt.Errorf("%w: component %q not covered", ErrMessageSignatureInvalid, required)
+		}
+	}
+	if verifier.MaxAge > 0 {
+		created, err := strconv.ParseInt(input.params["created"], 10, 64)
+		if err != nil || time.Since(time.Unix(created, 0)) > verifier.MaxAge {
+			return fmt.Errorf("%w: signature too old", ErrMessageSignatureInvalid)
+		}
+	}
+	if expires, ok := input.params["expires"]; ok {
+		if seconds, err := strconv.ParseInt(expires, 10, 64); err != nil || time.Now().Unix() > seconds {
+			return fmt.Errorf("%w: signature expired", ErrMessageSignatureInvalid)
+		}
+	}
+	algorithm, key, err := verifier.Keys(input.params["keyid"])
+	if err != nil {
+		return err
+	}
+	if alg, ok := input.params["alg"]; ok && alg != algorithm {
+		return fmt.Errorf("%w: algorithm %q not allowed for key", ErrMessageSignatureInvalid, alg)
+	}
+	if slices.Contains(input.items, "content-digest") {
+		if err = verifyContentDigest(r, verifier.MaxBodySize); err != nil {
+			return err
+		}
+	}
+	base, err := messageSignatureBase(r, input.items, input.raw)
+	if err != nil {
+		return err
+	}
+	if !messageVerify(algorithm, key, base, signature.bytes) {
+		return ErrMessageSignatureInvalid
+	}
+	return nil
+}
+
+// messageSigParams are the signature parameters of a message signature.
+type messageSigParams struct {
+	components []string
+	created    int64
+	expires    int64
+	keyID      string
+	alg        string
+	tag        string
+}
+
+// String serializes p as the value of @signature-params.
+func (p messageSigParams) String() string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, component := range p.components {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(quoteString(component))
+	}
+	b.WriteByte(')')
+	fmt.Fprintf(&b, ";created=%d", p.created)
+	if p.expires != 0 {
+		fmt.Fprintf(&b, ";expires=%d", p.expires)
+	}
+	if p.keyID != "" {
+		b.WriteString(";keyid=" + quoteString(p.keyID))
+	}
+	if p.alg != "" {
+		b.WriteString(";alg=" + quoteString(p.alg))
+	}
+	if p.tag != "" {
+		b.WriteString(";tag=" + quoteString(p.tag))
+	}
+	return b.String()
+}
+
+// messageSignatureBase creates the signature base of req (RFC 9421 section 2.5).
+func messageSignatureBase(req *http.Request, components []string, params string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, component := range components {
+		value, err := messageComponent(req, component)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(quoteString(component) + ": " + value + "\n")
+	}
+	b.WriteString(`"@signature-params": ` + params)
+	return b.Bytes(), nil
+}
+
+// messageComponent returns the value of a component of req.
+func messageComponent(req *http.Request, component string) (string, error) {
+	switch component {
+	case "@method":
+		return req.Method, nil
+	case "@authority":
+		return strings.ToLower(requestHost(req)), nil
+	case "@scheme":
+		if req.URL.Scheme != "" {
+			return strings.ToLower(req.URL.Scheme), nil
+		}
+		if req.TLS != nil {
+			return "https", nil
+		}
+		return "http", nil
+	case "@target-uri":
+		scheme, _ := messageComponent(req, "@scheme")
+		return scheme + "://" + strings.ToLower(requestHost(req)) + req.URL.RequestURI(), nil
+	case "@request-target":
+		return req.URL.RequestURI(), nil
+	case "@path":
+		if p := req.URL.EscapedPath(); p != "" {
+			return p, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + req.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(component, "@") || strings.ContainsAny(component, ";\"") {
+		return "", fmt.Errorf("httputil: httpsig: unsupported component %q", component)
+	}
+	values := req.Header.Values(component)
+	if len(values) == 0 && component == "content-length" && req.ContentLength > 0 {
+		values = []string{strconv.FormatInt(req.ContentLength, 10)}
+	}
+	if len(values) == 0 && component == "host" {
+		values = []string{requestHost(req)}
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("httputil: httpsig: missing header %q", component)
+	}
+	// values is the slice of the header map, which must not be modified
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+// verifyContentDigest checks the sha-256 or sha-512 Content-Digest of r
+// against its body, read up to limit bytes.
+func verifyContentDigest(r *http.Request, limit int64) error {
+	body, err := readBodyLimit(r, limit)
+	if err != nil {
+		return err
+	}
+	for _, digest := range parseSFDictionary(strings.Join(r.Header.Values("Content-Digest"), ", ")) {
+		var sum []byte
+		switch digest.name {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		if !hmac.Equal(sum, digest.bytes) {
+			return fmt.Errorf("%w: content digest mismatch", ErrMessageSignatureInvalid)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: no supported content digest", ErrMessageSignatureInvalid)
+}
+
+// messageSign signs base with key.
+func messageSign(algorithm string, key any, base []byte) ([]byte, error) {
+	switch algorithm {
+	case MessageSigEd25519:
+		if k, ok := key.(ed25519.PrivateKey); ok {
+			return ed25519.Sign(k, base), nil
+		}
+	case MessageSigECDSAP256SHA256:
+		if k, ok := key.(*ecdsa.PrivateKey); ok {
+			digest := sha256.Sum256(base)
+			der, err := ecdsa.SignASN1(rand.Reader, k, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			return ecdsaRawSignature(der, 32)
+		}
+	case MessageSigRSAPSSSHA512:
+		if k, ok := key.(*rsa.PrivateKey); ok {
+			digest := sha512.Sum512(base)
+			return rsa.SignPSS(rand.Reader, k, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: 64})
+		}
+	case MessageSigHMACSHA256:
+		if k, ok := key.([]byte); ok {
+			mac := hmac.New(sha256.New, k)
+			mac.Write(base)
+			return mac.Sum(nil), nil
+		}
+	default:
+		return nil, fmt.Errorf("httputil: httpsig: unsupported algorithm %q", algorithm)
+	}
+	return nil, fmt.Errorf("httputil: httpsig: invalid key %T for %s", key, algorithm)
+}
+
+// messageVerify verifies signature of base with key.
+func messageVerify(algorithm string, key any, base, signature []byte) bool {
+	switch algorithm {
+	case MessageSigEd25519:
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, base, signature)
+	case MessageSigECDSAP256SHA256:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		digest := sha256.Sum256(base)
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	case MessageSigRSAPSSSHA512:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha512.Sum512(base)
+		return rsa.VerifyPSS(k, crypto.SHA512, digest[:], signature, &rsa.PSSOptions{SaltLength: 64}) == nil
+	case MessageSigHMACSHA256:
+		k, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(base)
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+	return false
+}
+
+// sfMember is a member of a structured field dictionary.
+type sfMember struct {
+	name      string
+	raw       string
+	innerList bool
+	items     []string
+	bytes     []byte
+	params    map[string]string
+}
+
+// parseSFDictionary parses the subset of structured field dictionaries
+// (RFC 8941) used by message signatures and digests: members are inner lists
+// of strings or byte sequences, with parameters. Parameter values are kept as
+// their unquoted text. Parsing stops at the first malformed member.
+func parseSFDictionary(s string) []sfMember {
+	var members []sfMember
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return members
+		}
+		name, rest := parseSFKey(s)
+		if name == "" || !strings.HasPrefix(rest, "=") {
+			return members
+		}
+		rest = rest[1:]
+		member := sfMember{name: name, params: map[string]string{}}
+		start := rest
+		switch {
+		case strings.HasPrefix(rest, "("):
+			member.innerList = true
+			rest = rest[1:]
+			for {
+				rest = strings.TrimLeft(rest, " ")
+				if strings.HasPrefix(rest, ")") {
+					rest = rest[1:]
+					break
+				}
+				if !strings.HasPrefix(rest, `"`) {
+					return members
+				}
+				var item string
+				item, rest = parseQuoted(rest)
+				member.items = append(member.items, item)
+				// item parameters are not supported
+				if strings.HasPrefix(rest, ";") {
+					return members
+				}
+			}
+		case strings.HasPrefix(rest, ":"):
+			end := strings.IndexByte(rest[1:], ':')
+			if end < 0 {
+				return members
+			}
+			decoded, err := base64.StdEncoding.DecodeString(rest[1 : end+1])
+			if err != nil {
+				return members
+			}
+			member.bytes = decoded
+			rest = rest[end+2:]
+		default:
+			var value string
+			value, rest = parseToken(rest)
+			if value == "" {
+				return members
+			}
+		}
+		for strings.HasPrefix(rest, ";") {
+			var key, value string
+			key, rest = parseSFKey(rest[1:])
+			if key == "" {
+				return members
+			}
+			value = "?1"
+			if strings.HasPrefix(rest, "=") {
+				if strings.HasPrefix(rest[1:], `"`) {
+					value, rest = parseQuoted(rest[1:])
+				} else {
+					value, rest = parseToken(rest[1:])
+				}
+			}
+			member.params[key] = value
+		}
+		member.raw = start[:len(start)-len(rest)]
+		members = append(members, member)
+		s = rest
+	}
+}
+
+// parseSFKey splits a leading structured field key off s.
+func parseSFKey(s string) (key, rest string) {
+	i := 0
+	for i < len(s) && (s[i] >= 'a' && s[i] <= 'z' || s[i] >= '0' && s[i] <= '9' || strings.IndexByte("_-.*", s[i]) >= 0) {
+		i++
+	}
+	return s[:i], s[i:]
+}
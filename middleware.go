@@ -0,0 +1,32 @@
+package httputil
+
+import "net/http"
+
+// Middleware wraps the http.RoundTripper of a Client with additional
+// behaviour, such as signing or logging requests.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an adapter to allow the use of ordinary functions as http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// SignerMiddleware returns a Middleware calling sign with a copy of every
+// request before it is sent, including the requests of followed redirects.
+func SignerMiddleware(sign func(req *http.Request) error) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			signReq := req.Clone(req.Context())
+			if err := sign(signReq); err != nil {
+				if req.Body != nil {
+					req.Body.Close()
+				}
+				return nil, err
+			}
+			return next.RoundTrip(signReq)
+		})
+	}
+}
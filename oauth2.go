@@ -0,0 +1,438 @@
+package httputil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2GrantType is the grant used to obtain OAuth2 access tokens.
+type OAuth2GrantType string
+
+const (
+	// OAuth2ClientCredentials is the client credentials grant (RFC 6749 section 4.4).
+	OAuth2ClientCredentials OAuth2GrantType = "client_credentials"
+	// OAuth2RefreshToken is the refresh token grant (RFC 6749 section 6).
+	OAuth2RefreshToken OAuth2GrantType = "refresh_token"
+	// OAuth2Password is the resource owner password credentials grant (RFC 6749 section 4.3).
+	OAuth2Password OAuth2GrantType = "password"
+)
+
+// OAuth2AuthStyle is how the client authenticates to the token endpoint.
+type OAuth2AuthStyle int
+
+const (
+	// OAuth2AuthBasic sends the client credentials with HTTP Basic authentication.
+	OAuth2AuthBasic OAuth2AuthStyle = iota
+	// OAuth2AuthParams sends the client credentials in the request body.
+	OAuth2AuthParams
+	// OAuth2AuthPrivateKeyJWT sends a JWT signed with PrivateKey as client assertion (RFC 7523).
+	OAuth2AuthPrivateKeyJWT
+)
+
+// OAuth2Config configures an OAuth2 authenticator.
+type OAuth2Config struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string
+	// ClientID and ClientSecret are the client credentials.
+	ClientID     string
+	ClientSecret string
+	// Scopes are the requested scopes.
+	Scopes []string
+	// GrantType is the grant used to obtain tokens. Defaults to OAuth2ClientCredentials.
+	GrantType OAuth2GrantType
+	// Username and Password are the resource owner credentials of the password grant.
+	Username string
+	Password string
+	// RefreshToken is the initial refresh token of the refresh token grant.
+	RefreshToken string
+	// EndpointParams are additional parameters sent to the token endpoint.
+	EndpointParams url.Values
+	// AuthStyle is how the client authenticates to the token endpoint.
+	AuthStyle OAuth2AuthStyle
+	// PrivateKey signs the client assertion of OAuth2AuthPrivateKeyJWT.
+	// RSA (RS256), ECDSA P-256 (ES256) and Ed25519 (EdDSA) keys are supported.
+	PrivateKey crypto.Signer
+	// KeyID is the optional "kid" header of the client assertion.
+	KeyID string
+	// ExpiryDelta is how long before its expiry a token is refreshed. Defaults to 30 seconds.
+	ExpiryDelta time.Duration
+	// HTTPClient is used to call the token endpoint. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// OAuth2Token is an OAuth2 access token.
+type OAuth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitzero"`
+}
+
+// OAuth2Error is an error response of the token endpoint (RFC 6749 section 5.2).
+type OAuth2Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	URI         string `json:"error_uri"`
+}
+
+// Error implements error.
+func (e *OAuth2Error) Error() string {
+	msg := fmt.Sprintf("httputil: oauth2: %s (status %d)", e.Code, e.StatusCode)
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// OAuth2 is an Authenticator obtaining bearer tokens from an OAuth2
+// authorization server.
+//
+// Tokens are cached and refreshed shortly before they expire; concurrent
+// requests share a single refresh. A request answered with 401 and
+// `WWW-Authenticate: Bearer error="invalid_token"` is retried once with a new
+// token.
+type OAuth2 struct {
+	config OAuth2Config
+
+	mu     sync.Mutex
+	token  *OAuth2Token
+	flight *oauth2Flight
+}
+
+// oauth2TokenTimeout bounds token requests when the HTTP client of the
+// token endpoint has no timeout.
+const oauth2TokenTimeout = 30 * time.Second
+
+// oauth2Flight is an in-flight token request.
+type oauth2Flight struct {
+	done  chan struct{}
+	token *OAuth2Token
+	err   error
+}
+
+// NewOAuth2 new OAuth2 authenticator
+func NewOAuth2(config OAuth2Config) *OAuth2 {
+	if config.GrantType == "" {
+		config.GrantType = OAuth2ClientCredentials
+	}
+	if config.ExpiryDelta == 0 {
+		config.ExpiryDelta = 30 * time.Second
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &OAuth2{config: config}
+}
+
+// SetToken replaces the cached token, e.g. with a token saved earlier.
+func (o *OAuth2) SetToken(token *OAuth2Token) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.token = token
+}
+
+// cachedToken returns the cached token, without requesting one.
+func (o *OAuth2) cachedToken() *OAuth2Token {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.token
+}
+
+// Token returns a valid token, requesting a new one if needed.
+func (o *OAuth2) Token(ctx context.Context) (*OAuth2Token, error) {
+	o.mu.Lock()
+	if o.valid(o.token) {
+		token := o.token
+		o.mu.Unlock()
+		return token, nil
+	}
+	flight := o.flight
+	if flight == nil {
+		flight = &oauth2Flight{done: make(chan struct{})}
+		o.flight = flight
+		// the token is shared by all requests, so a canceled request does
+		// not cancel its refresh
+		go o.refresh(context.WithoutCancel(ctx), flight, o.token)
+	}
+	o.mu.Unlock()
+	select {
+	case <-flight.done:
+		return flight.token, flight.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refresh completes flight with a new token, requested within the timeout
+// of the HTTP client, or oauth2TokenTimeout if it has none.
+func (o *OAuth2) refresh(ctx context.Context, flight *oauth2Flight, current *OAuth2Token) {
+	timeout := oauth2TokenTimeout
+	if o.config.HTTPClient.Timeout > 0 {
+		timeout = o.config.HTTPClient.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	flight.token, flight.err = o.fetch(ctx, current)
+	o.mu.Lock()
+	if flight.err == nil {
+		o.token = flight.token
+	}
+	o.flight = nil
+	o.mu.Unlock()
+	close(flight.done)
+}
+
+// Authenticate implements Authenticator.
+func (o *OAuth2) Authenticate(req *http.Request) error {
+	token, err := o.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return nil
+}
+
+// HandleChallenge implements ChallengeHandler. It discards the cached token
+// when the server reports it as invalid.
+func (o *OAuth2) HandleChallenge(resp *http.Response) (bool, error) {
+	invalid := false
+	for _, challenge := range parseChallenges(resp.Header.Values("WWW-Authenticate")) {
+		if strings.EqualFold(challenge.Scheme, "Bearer") && challenge.Params["error"] == "invalid_token" {
+			invalid = true
+		}
+	}
+	if !invalid {
+		return false, nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// keep a token refreshed by a concurrent request meanwhile
+	if o.token != nil && strings.HasSuffix(resp.Request.Header.Get("Authorization"), " "+o.token.AccessToken) {
+		o.token = &OAuth2Token{RefreshToken: o.token.RefreshToken}
+	}
+	return true, nil
+}
+
+// valid reports whether token can be used without refreshing.
+func (o *OAuth2) valid(token *OAuth2Token) bool {
+	if token == nil || token.AccessToken == "" {
+		return false
+	}
+	return token.Expiry.IsZero() || time.Now().Add(o.config.ExpiryDelta).Before(token.Expiry)
+}
+
+// fetch requests a new token, using the refresh token of current if any.
+func (o *OAuth2) fetch(ctx context.Context, current *OAuth2Token) (*OAuth2Token, error) {
+	refreshToken := o.config.RefreshToken
+	if current != nil && current.RefreshToken != "" {
+		refreshToken = current.RefreshToken
+	}
+	if refreshToken != "" {
+		token, err := o.request(ctx, url.Values{
+			"grant_type":    {string(OAuth2RefreshToken)},
+			"refresh_token": {refreshToken},
+		})
+		if err == nil {
+			if token.RefreshToken == "" {
+				token.RefreshToken = refreshToken
+			}
+			return token, nil
+		}
+		var oauthErr *OAuth2Error
+		if o.config.GrantType == OAuth2RefreshToken || !errors.As(err, &oauthErr) {
+			return nil, err
+		}
+		// the refresh token was rejected, fall back to the configured grant
+	}
+	params := url.Values{"grant_type": {string(o.config.GrantType)}}
+	switch o.config.GrantType {
+	case OAuth2ClientCredentials:
+	case OAuth2Password:
+		params.Set("username", o.config.Username)
+		params.Set("password", o.config.Password)
+	case OAuth2RefreshToken:
+		return nil, errors.New("httputil: oauth2: no refresh token")
+	default:
+		return nil, fmt.Errorf("httputil: oauth2: unsupported grant type %q", o.config.GrantType)
+	}
+	return o.request(ctx, params)
+}
+
+// request calls the token endpoint with params.
+func (o *OAuth2) request(ctx context.Context, params url.Values) (*OAuth2Token, error) {
+	for key, values := range o.config.EndpointParams {
+		params[key] = values
+	}
+	if len(o.config.Scopes) > 0 {
+		params.Set("scope", strings.Join(o.config.Scopes, " "))
+	}
+	switch o.config.AuthStyle {
+	case OAuth2AuthBasic:
+	case OAuth2AuthParams:
+		params.Set("client_id", o.config.ClientID)
+		if o.config.ClientSecret != "" {
+			params.Set("client_secret", o.config.ClientSecret)
+		}
+	case OAuth2AuthPrivateKeyJWT:
+		assertion, err := o.clientAssertion()
+		if err != nil {
+			return nil, err
+		}
+		params.Set("client_id", o.config.ClientID)
+		params.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		params.Set("client_assertion", assertion)
+	default:
+		return nil, fmt.Errorf("httputil: oauth2: unsupported auth style %d", o.config.AuthStyle)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.config.TokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.config.AuthStyle == OAuth2AuthBasic {
+		req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+	}
+	resp, err := o.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		oauthErr := &OAuth2Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(body, oauthErr) != nil || oauthErr.Code == "" {
+			oauthErr.Code = "server_error"
+			oauthErr.Description = strings.TrimSpace(string(body))
+		}
+		return nil, oauthErr
+	}
+	var res struct {
+		AccessToken  string      `json:"access_token"`
+		TokenType    string      `json:"token_type"`
+		RefreshToken string      `json:"refresh_token"`
+		ExpiresIn    json.Number `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("httputil: oauth2: decode token response: %w", err)
+	}
+	if res.AccessToken == "" {
+		return nil, errors.New("httputil: oauth2: token response without access_token")
+	}
+	token := &OAuth2Token{
+		AccessToken:  res.AccessToken,
+		TokenType:    res.TokenType,
+		RefreshToken: res.RefreshToken,
+	}
+	if seconds, err := res.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		token.Expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	return token, nil
+}
+
+// clientAssertion returns a JWT authenticating the client (RFC 7523 section 2.2).
+func (o *OAuth2) clientAssertion() (string, error) {
+	if o.config.PrivateKey == nil {
+		return "", errors.New("httputil: oauth2: private_key_jwt requires a private key")
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return signJWT(o.config.PrivateKey, o.config.KeyID, map[string]any{
+		"iss": o.config.ClientID,
+		"sub": o.config.ClientID,
+		"aud": o.config.TokenURL,
+		"jti": hex.EncodeToString(jti),
+		"iat": now.Unix(),
+		"exp": now.Add(5 * time.Minute).Unix(),
+	})
+}
+
+// signJWT returns a compact JWS of claims signed with key.
+func signJWT(key crypto.Signer, keyID string, claims map[string]any) (string, error) {
+	header := map[string]string{"typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	var hash crypto.Hash
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		header["alg"], hash = "RS256", crypto.SHA256
+	case *ecdsa.PublicKey:
+		if pub.Curve.Params().BitSize != 256 {
+			return "", errors.New("httputil: jwt: only P-256 ECDSA keys are supported")
+		}
+		header["alg"], hash = "ES256", crypto.SHA256
+	case ed25519.PublicKey:
+		header["alg"] = "EdDSA"
+	default:
+		return "", fmt.Errorf("httputil: jwt: unsupported key type %T", pub)
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := []byte(signingInput)
+	if hash != 0 {
+		sum := sha256.Sum256(digest)
+		digest = sum[:]
+	}
+	signature, err := key.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return "", err
+	}
+	if header["alg"] == "ES256" {
+		if signature, err = ecdsaRawSignature(signature, 32); err != nil {
+			return "", err
+		}
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ecdsaRawSignature converts an ASN.1 ECDSA signature to the fixed size r||s
+// form used by JWS and HTTP message signatures.
+func ecdsaRawSignature(der []byte, size int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
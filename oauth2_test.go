@@ -0,0 +1,144 @@
+package httputil
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	var issued atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "id", user)
+		assert.Equal(t, "secret", pass)
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		assert.Equal(t, "read write", r.FormValue("scope"))
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token%d","token_type":"bearer","expires_in":3600}`, n)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		// the first token is revoked
+		if r.Header.Get("Authorization") == "Bearer token1" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := NewClient(context.Background(), WithOAuth2(OAuth2Config{
+		TokenURL:     ts.URL + "/token",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	}))
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(ts.URL + "/api")
+			assert.Nil(t, err)
+			body, err := ReadString(resp)
+			assert.Nil(t, err)
+			assert.Equal(t, "Bearer token2", body)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), issued.Load())
+}
+
+func TestOAuth2RefreshToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "refresh_token", r.FormValue("grant_type"))
+		assert.Equal(t, "refresh", r.FormValue("refresh_token"))
+		assert.Equal(t, "id", r.FormValue("client_id"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access","expires_in":"3600"}`))
+	}))
+	defer ts.Close()
+	o := NewOAuth2(OAuth2Config{
+		TokenURL:     ts.URL,
+		ClientID:     "id",
+		GrantType:    OAuth2RefreshToken,
+		RefreshToken: "refresh",
+		AuthStyle:    OAuth2AuthParams,
+	})
+	token, err := o.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "access", token.AccessToken)
+	assert.Equal(t, "refresh", token.RefreshToken)
+	assert.False(t, token.Expiry.IsZero())
+}
+
+func TestOAuth2PrivateKeyJWT(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", r.FormValue("client_assertion_type"))
+		parts := strings.Split(r.FormValue("client_assertion"), ".")
+		assert.Len(t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		assert.Nil(t, err)
+		assert.True(t, ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), signature))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_client","error_description":"unknown client"}`))
+	}))
+	defer ts.Close()
+	o := NewOAuth2(OAuth2Config{
+		TokenURL:   ts.URL,
+		ClientID:   "id",
+		AuthStyle:  OAuth2AuthPrivateKeyJWT,
+		PrivateKey: priv,
+	})
+	_, err = o.Token(context.Background())
+	var oauthErr *OAuth2Error
+	assert.ErrorAs(t, err, &oauthErr)
+	assert.Equal(t, "invalid_client", oauthErr.Code)
+	assert.Equal(t, http.StatusBadRequest, oauthErr.StatusCode)
+}
+
+func TestOAuth2CanceledRefresh(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+	}))
+	defer ts.Close()
+	o := NewOAuth2(OAuth2Config{TokenURL: ts.URL, ClientID: "id", ClientSecret: "secret"})
+
+	// the request starting the refresh is canceled, the others still get the token
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := o.Token(ctx)
+		leader <- err
+	}()
+	cancel()
+	assert.ErrorIs(t, <-leader, context.Canceled)
+	waiter := make(chan *OAuth2Token)
+	go func() {
+		token, err := o.Token(context.Background())
+		assert.Nil(t, err)
+		waiter <- token
+	}()
+	close(release)
+	assert.Equal(t, "token", (<-waiter).AccessToken)
+}
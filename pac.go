@@ -0,0 +1,558 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	// pacCacheTTL is how long the result of FindProxyForURL is cached.
+	pacCacheTTL = 5 * time.Minute
+	// pacCacheSize is the maximum number of cached results.
+	pacCacheSize = 1024
+	// pacTimeout bounds the evaluation of FindProxyForURL.
+	pacTimeout = 5 * time.Second
+	// pacFetchTimeout bounds the fetch of a script URL.
+	pacFetchTimeout = 30 * time.Second
+	// pacMaxSize is the maximum size of a script.
+	pacMaxSize = 1 << 20
+	// pacRetryDelay is how long a script that failed to load is not loaded
+	// again, failing requests with the same error.
+	pacRetryDelay = 30 * time.Second
+)
+
+// pacHelpers implements the standard PAC helper functions not provided by Go.
+const pacHelpers = `
+var pacDays = ['SUN', 'MON', 'TUE', 'WED', 'THU', 'FRI', 'SAT'];
+var pacMonths = ['JAN', 'FEB', 'MAR', 'APR', 'MAY', 'JUN', 'JUL', 'AUG', 'SEP', 'OCT', 'NOV', 'DEC'];
+
+function isPlainHostName(host) {
+	return String(host).indexOf('.') < 0;
+}
+
+function dnsDomainIs(host, domain) {
+	host = String(host).toLowerCase();
+	domain = String(domain).toLowerCase();
+	return host.length >= domain.length && host.substring(host.length - domain.length) == domain;
+}
+
+function localHostOrDomainIs(host, hostdom) {
+	return host == hostdom || String(hostdom).lastIndexOf(host + '.', 0) == 0;
+}
+
+function isResolvable(host) {
+	return dnsResolve(host) != null;
+}
+
+function convert_addr(ipchars) {
+	var b = String(ipchars).split('.');
+	return ((b[0] & 0xff) << 24) | ((b[1] & 0xff) << 16) | ((b[2] & 0xff) << 8) | (b[3] & 0xff);
+}
+
+function isInNet(host, pattern, mask) {
+	var ip = /^\d+\.\d+\.\d+\.\d+$/.test(host) ? host : dnsResolve(host);
+	if (ip == null) {
+		return false;
+	}
+	return (convert_addr(ip) & convert_addr(mask)) == (convert_addr(pattern) & convert_addr(mask));
+}
+
+function dnsDomainLevels(host) {
+	return String(host).split('.').length - 1;
+}
+
+function shExpMatch(str, shexp) {
+	var re = String(shexp).replace(/[.+^${}()|[\]\\]/g, '\\$&').replace(/\*/g, '.*').replace(/\?/g, '.');
+	return new RegExp('^' + re + '$').test(str);
+}
+
+function pacArgs(args) {
+	args = Array.prototype.slice.call(args);
+	var gmt = args.length > 0 && args[args.length - 1] == 'GMT';
+	if (gmt) {
+		args.pop();
+	}
+	return {args: args, gmt: gmt, now: new Date()};
+}
+
+function pacInRange(start, now, end, inclusive) {
+	if (start <= end) {
+		return start <= now && (inclusive ? now <= end : now < end);
+	}
+	return now >= start || (inclusive ? now <= end : now < end);
+}
+
+function weekdayRange() {
+	var a = pacArgs(arguments);
+	var today = a.gmt ? a.now.getUTCDay() : a.now.getDay();
+	var wd1 = pacDays.indexOf(String(a.args[0]).toUpperCase());
+	if (wd1 < 0) {
+		return false;
+	}
+	if (a.args.length < 2) {
+		return today == wd1;
+	}
+	var wd2 = pacDays.indexOf(String(a.args[1]).toUpperCase());
+	return wd2 >= 0 && pacInRange(wd1, today, wd2, true);
+}
+
+function pacDate(args) {
+	var d = {};
+	for (var i = 0; i < args.length; i++) {
+		var m = pacMonths.indexOf(String(args[i]).toUpperCase());
+		if (m >= 0) {
+			d.month = m;
+		} else if (isNaN(Number(args[i]))) {
+			return null;
+		} else if (Number(args[i]) > 31) {
+			d.year = Number(args[i]);
+		} else {
+			d.day = Number(args[i]);
+		}
+	}
+	return d;
+}
+
+function pacDateKey(kinds, d) {
+	return ('year' in kinds ? d.year * 10000 : 0) + ('month' in kinds ? d.month * 100 : 0) + ('day' in kinds ? d.day : 0);
+}
+
+function dateRange() {
+	var a = pacArgs(arguments);
+	var now = a.gmt ?
+		{year: a.now.getUTCFullYear(), month: a.now.getUTCMonth(), day: a.now.getUTCDate()} :
+		{year: a.now.getFullYear(), month: a.now.getMonth(), day: a.now.getDate()};
+	var n = a.args.length;
+	if (n == 0 || n > 6) {
+		return false;
+	}
+	if (n % 2 == 1) {
+		var d = pacDate(a.args);
+		return d != null && pacDateKey(d, d) == pacDateKey(d, now);
+	}
+	var start = pacDate(a.args.slice(0, n / 2)), end = pacDate(a.args.slice(n / 2));
+	return start != null && end != null && pacInRange(pacDateKey(start, start), pacDateKey(start, now), pacDateKey(end, end), true);
+}
+
+function timeRange() {
+	var a = pacArgs(arguments);
+	var now = a.gmt ?
+		[a.now.getUTCHours(), a.now.getUTCMinutes(), a.now.getUTCSeconds()] :
+		[a.now.getHours(), a.now.getMinutes(), a.now.getSeconds()];
+	var n = a.args.length;
+	if (n == 1) {
+		return now[0] == Number(a.args[0]);
+	}
+	if (n != 2 && n != 4 && n != 6) {
+		return false;
+	}
+	var key = function(t) {
+		var k = 0;
+		for (var i = 0; i < 3; i++) {
+			k = k * 60 + (i < n / 2 ? Number(t[i]) : 0);
+		}
+		return k;
+	};
+	return pacInRange(key(a.args.slice(0, n / 2)), key(now), key(a.args.slice(n / 2)), false);
+}
+`
+
+// pacHelpersProgram returns the compiled pacHelpers, run in every runtime.
+var pacHelpersProgram = sync.OnceValue(func() *goja.Program {
+	return goja.MustCompile("pac-helpers.js", pacHelpers, false)
+})
+
+// pacScript evaluates a proxy auto-config script, fetched from a URL or read
+// from a file on first use. Evaluations run concurrently, each in a runtime
+// of a pool.
+type pacScript struct {
+	source   string
+	resolver *resolver
+
+	program atomic.Pointer[goja.Program]
+	loadMu  sync.Mutex
+	loadErr error
+	retryAt time.Time
+	vms     sync.Pool
+
+	mu    sync.Mutex
+	cache map[string]pacResult
+	now   func() time.Time
+}
+
+// pacResult is a cached result of FindProxyForURL.
+type pacResult struct {
+	proxies []*url.URL
+	expires time.Time
+}
+
+// pacVM is a runtime of a script.
+type pacVM struct {
+	vm       *goja.Runtime
+	find     goja.Callable
+	resolver *resolver
+	// ctx is the context of the current evaluation.
+	ctx context.Context
+}
+
+// proxies returns the proxies to try in order for u; a nil proxy means a
+// direct connection.
+func (p *pacScript) proxies(ctx context.Context, u *url.URL) ([]*url.URL, error) {
+	target := u.String()
+	if u.Scheme == "https" {
+		// like browsers, only expose the origin of https URLs to the script
+		target = u.Scheme + "://" + u.Host + "/"
+	}
+	host := strings.ToLower(u.Hostname())
+	key := target + " " + host
+
+	p.mu.Lock()
+	result, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && p.time().Before(result.expires) {
+		return result.proxies, nil
+	}
+	program, err := p.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := p.vms.Get().(*pacVM)
+	if m == nil {
+		if m, err = p.newVM(program); err != nil {
+			return nil, err
+		}
+	}
+	defer p.vms.Put(m)
+	value, err := m.findProxy(ctx, target, host)
+	if err != nil {
+		return nil, err
+	}
+	proxies := parsePACResult(value)
+	p.mu.Lock()
+	if p.cache == nil || len(p.cache) >= pacCacheSize {
+		p.cache = make(map[string]pacResult)
+	}
+	p.cache[key] = pacResult{proxies: proxies, expires: p.time().Add(pacCacheTTL)}
+	p.mu.Unlock()
+	return proxies, nil
+}
+
+// load fetches and compiles the script once. Concurrent calls wait for the
+// same load, and a failed load is retried after pacRetryDelay.
+func (p *pacScript) load(ctx context.Context) (*goja.Program, error) {
+	if program := p.program.Load(); program != nil {
+		return program, nil
+	}
+	p.loadMu.Lock()
+	defer p.loadMu.Unlock()
+	if program := p.program.Load(); program != nil {
+		return program, nil
+	}
+	if p.loadErr != nil && p.time().Before(p.retryAt) {
+		return nil, p.loadErr
+	}
+	// the script is shared by all requests, so a canceled request does
+	// not fail its load
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pacFetchTimeout)
+	defer cancel()
+	script, err := p.fetch(ctx)
+	var program *goja.Program
+	if err == nil {
+		program, err = goja.Compile(p.source, script, false)
+	}
+	if err == nil {
+		var m *pacVM
+		if m, err = p.newVM(program); err == nil {
+			p.vms.Put(m)
+		}
+	}
+	if err != nil {
+		p.loadErr, p.retryAt = fmt.Errorf("httputil: pac: %w", err), p.time().Add(pacRetryDelay)
+		return nil, p.loadErr
+	}
+	p.program.Store(program)
+	return program, nil
+}
+
+// newVM returns a new runtime of the compiled script program.
+func (p *pacScript) newVM(program *goja.Program) (*pacVM, error) {
+	m := &pacVM{vm: goja.New(), resolver: p.resolver}
+	vm := m.vm
+	vm.Set("dnsResolve", m.dnsResolve)
+	vm.Set("dnsResolveEx", m.dnsResolveEx)
+	vm.Set("isResolvableEx", func(host string) bool {
+		return m.dnsResolveEx(host) != ""
+	})
+	vm.Set("isInNetEx", m.isInNetEx)
+	vm.Set("myIpAddress", myIPAddress)
+	vm.Set("myIpAddressEx", myIPAddressEx)
+	vm.Set("alert", func(string) {})
+	if _, err := vm.RunProgram(pacHelpersProgram()); err != nil {
+		return nil, err
+	}
+	if _, err := vm.RunProgram(program); err != nil {
+		return nil, err
+	}
+	find, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, errors.New("FindProxyForURL is not defined")
+	}
+	m.find = find
+	return m, nil
+}
+
+// findProxy evaluates FindProxyForURL, for at most pacTimeout.
+func (m *pacVM) findProxy(ctx context.Context, target, host string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pacTimeout)
+	defer cancel()
+	m.ctx = ctx
+	defer func() { m.ctx = nil }()
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		m.vm.Interrupt(ctx.Err())
+		close(interrupted)
+	})
+	value, err := m.find(goja.Undefined(), m.vm.ToValue(target), m.vm.ToValue(host))
+	if !stop() {
+		<-interrupted
+		m.vm.ClearInterrupt()
+	}
+	if err != nil {
+		return "", fmt.Errorf("httputil: pac: FindProxyForURL: %w", err)
+	}
+	return value.String(), nil
+}
+
+// fetch returns the script from its URL or file, up to pacMaxSize bytes.
+func (p *pacScript) fetch(ctx context.Context) (string, error) {
+	u, err := url.Parse(p.source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+		return readPACFile(p.source)
+	}
+	if u.Scheme == "file" {
+		return readPACFile(u.Path)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.source, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := (&http.Client{Timeout: pacFetchTimeout}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch %s: %s", u.Redacted(), resp.Status)
+	}
+	return readPAC(resp.Body)
+}
+
+// readPACFile reads the script of the file name.
+func readPACFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readPAC(f)
+}
+
+// readPAC reads a script of at most pacMaxSize bytes from r.
+func readPAC(r io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, pacMaxSize+1))
+	if err == nil && len(data) > pacMaxSize {
+		err = fmt.Errorf("script larger than %d bytes", pacMaxSize)
+	}
+	return string(data), err
+}
+
+// lookup resolves host with the context of the current evaluation.
+func (m *pacVM) lookup(host string) []netip.Addr {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}
+	}
+	var addrs []netip.Addr
+	var err error
+	if m.resolver != nil {
+		addrs, err = m.resolver.resolve(m.ctx, host, "")
+	} else {
+		addrs, err = net.DefaultResolver.LookupNetIP(m.ctx, "ip", host)
+	}
+	if err != nil {
+		return nil
+	}
+	return addrs
+}
+
+// dnsResolve returns the first IPv4 address of host, or null.
+func (m *pacVM) dnsResolve(host string) any {
+	for _, addr := range m.lookup(host) {
+		if addr.Unmap().Is4() {
+			return addr.Unmap().String()
+		}
+	}
+	return nil
+}
+
+// dnsResolveEx returns the semicolon-separated addresses of host.
+func (m *pacVM) dnsResolveEx(host string) string {
+	var addrs []string
+	for _, addr := range m.lookup(host) {
+		addrs = append(addrs, addr.Unmap().String())
+	}
+	return strings.Join(addrs, ";")
+}
+
+// isInNetEx reports whether an address of host is in the CIDR prefix.
+func (m *pacVM) isInNetEx(host, prefix string) bool {
+	network, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return false
+	}
+	for _, addr := range m.lookup(host) {
+		if network.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// time returns the current time.
+func (p *pacScript) time() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// myIPAddress returns the IPv4 address of the interface of the default route.
+func myIPAddress() string {
+	if addr := localAddr("udp4", "198.51.100.1:53"); addr != "" {
+		return addr
+	}
+	return "127.0.0.1"
+}
+
+// myIPAddressEx returns the semicolon-separated addresses of the interfaces
+// of the default routes.
+func myIPAddressEx() string {
+	var addrs []string
+	for _, network := range [][2]string{{"udp4", "198.51.100.1:53"}, {"udp6", "[2001:db8::1]:53"}} {
+		if addr := localAddr(network[0], network[1]); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return strings.Join(addrs, ";")
+}
+
+// localAddr returns the local address used to reach addr. Connecting a UDP
+// socket sends no packet.
+func localAddr(network, addr string) string {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// parsePACResult parses a FindProxyForURL result such as
+// "PROXY a:8080; SOCKS b:1080; DIRECT". Unsupported entries are skipped; an
+// empty result means a direct connection.
+func parsePACResult(result string) []*url.URL {
+	var proxies []*url.URL
+	for entry := range strings.SplitSeq(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		var scheme string
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			proxies = append(proxies, nil)
+			continue
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		if u, err := parseProxyURL(scheme + "://" + fields[1]); err == nil {
+			proxies = append(proxies, u)
+		}
+	}
+	if len(proxies) == 0 {
+		proxies = append(proxies, nil)
+	}
+	return proxies
+}
+
+// pacProxyKey is the context key of the proxy attempted by pacTransport.
+type pacProxyKey struct{}
+
+// pacSelector is the proxy selector of pacTransport requests.
+func pacSelector(req *http.Request) (*url.URL, error) {
+	u, _ := req.Context().Value(pacProxyKey{}).(*url.URL)
+	return u, nil
+}
+
+// pacTransport sends requests through the proxies returned by a PAC script,
+// trying the next one when a proxy fails. Requests with a body are only
+// retried if it can be replayed with GetBody.
+type pacTransport struct {
+	pac  *pacScript
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *pacTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxies, err := t.pac.proxies(req.Context(), req.URL)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	for i, proxy := range proxies {
+		r := req.WithContext(context.WithValue(req.Context(), pacProxyKey{}, proxy))
+		if i > 0 && req.Body != nil && req.Body != http.NoBody {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		var resp *http.Response
+		resp, err = t.next.RoundTrip(r)
+		var proxyErr *ProxyError
+		if err == nil || !errors.As(err, &proxyErr) || proxyErr.StatusCode != 0 {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transport.
+func (t *pacTransport) CloseIdleConnections() {
+	closeIdleConnections(t.next)
+}
@@ -0,0 +1,139 @@
+package httputil
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPACHelpers(t *testing.T) {
+	script := writeTestFile(t, "proxy.pac", []byte(`
+function FindProxyForURL(url, host) {
+	return [
+		isPlainHostName(host),
+		dnsDomainIs(host, ".example.com"),
+		localHostOrDomainIs("www", "www.example.com"),
+		/^\d/.test(host) && isInNet(host, "10.0.0.0", "255.0.0.0"),
+		/^\d/.test(host) && isInNetEx(host, "10.1.0.0/16"),
+		dnsResolve("127.0.0.1"),
+		dnsDomainLevels(host),
+		shExpMatch(url, "*://*.example.com/a?c*"),
+		shExpMatch(host, "(*"),
+		weekdayRange("SUN", "SAT"),
+		dateRange(1, 31),
+		dateRange("JAN", "DEC", "GMT"),
+		timeRange(0, 24),
+	].join(",");
+}`))
+	pac := &pacScript{source: script}
+	program, err := pac.load(context.Background())
+	assert.Nil(t, err)
+	m, err := pac.newVM(program)
+	assert.Nil(t, err)
+	for target, want := range map[string]string{
+		"http://www.example.com/abc": "false,true,true,false,false,127.0.0.1,2,true,false,true,true,true,true",
+		"http://intranet/":           "true,false,true,false,false,127.0.0.1,0,false,false,true,true,true,true",
+		"http://10.1.2.3/":           "false,false,true,true,true,127.0.0.1,3,false,false,true,true,true,true",
+	} {
+		u, _ := url.Parse(target)
+		value, err := m.findProxy(context.Background(), u.String(), u.Hostname())
+		assert.Nil(t, err)
+		assert.Equal(t, want, value, target)
+	}
+}
+
+func TestParsePACResult(t *testing.T) {
+	var got []string
+	for _, u := range parsePACResult("PROXY a:8080; socks b:1080;HTTPS c:443 ; SOCKS4 d:1080; PROXY; DIRECT") {
+		if u == nil {
+			got = append(got, "DIRECT")
+		} else {
+			got = append(got, u.String())
+		}
+	}
+	assert.Equal(t, []string{"http://a:8080", "socks5://b:1080", "https://c:443", "DIRECT"}, got)
+	assert.Equal(t, []*url.URL{nil}, parsePACResult(""))
+}
+
+func TestProxyPAC(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	}))
+	defer target.Close()
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("proxy " + string(body)))
+	}))
+	defer proxyServer.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	dead := l.Addr().String()
+	l.Close()
+
+	var fetches, missing atomic.Int32
+	pacServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			missing.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fetches.Add(1)
+		w.Write([]byte(`
+function FindProxyForURL(url, host) {
+	if (shExpMatch(url, "*/direct")) {
+		return "DIRECT";
+	}
+	return "PROXY ` + dead + `; PROXY ` + proxyServer.Listener.Addr().String() + `; DIRECT";
+}`))
+	}))
+	defer pacServer.Close()
+
+	c := NewClient(context.Background(), WithProxyPAC(pacServer.URL))
+	resp, err := c.Get(target.URL + "/direct")
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "target", body)
+
+	// falls back to the second proxy, replaying the body
+	for range 2 {
+		resp, err = c.Post(target.URL+"/proxied", "text/plain", strings.NewReader("data"))
+		assert.Nil(t, err)
+		body, _ = ReadString(resp)
+		assert.Equal(t, "proxy data", body)
+	}
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// concurrent requests share the evaluation runtimes
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(target.URL + "/concurrent/direct")
+			assert.Nil(t, err)
+			body, _ := ReadString(resp)
+			assert.Equal(t, "target", body)
+		}()
+	}
+	wg.Wait()
+
+	// a failed load is not retried at once
+	c = NewClient(context.Background(), WithProxyPAC(pacServer.URL+"/missing"))
+	for range 2 {
+		_, err = c.Get(target.URL)
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, int32(1), missing.Load())
+
+	_, err = NewClient(context.Background(), WithProxyPAC(writeTestFile(t, "bad.pac", []byte("function(")))).Get(target.URL)
+	assert.ErrorContains(t, err, "httputil: pac")
+}
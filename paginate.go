@@ -0,0 +1,365 @@
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page is a page of a paginated listing, see PageStrategy.
+type Page struct {
+	// Number is the number of the page, 1 for the first page.
+	Number int
+	// Request is the request of the page.
+	Request *http.Request
+	// Response is the response of the page, whose body is already read.
+	Response *http.Response
+	// Body is the body of the response.
+	Body []byte
+	// Items is the number of items of the page.
+	Items int
+	// Seen is the number of items of the page and the previous pages.
+	Seen int
+}
+
+// PageStrategy finds the pages of a paginated listing.
+type PageStrategy interface {
+	// Next returns the URL of the page following page, or nil if page is
+	// the last page.
+	Next(page *Page) (*url.URL, error)
+}
+
+// PageStrategyFunc is an adapter to allow the use of ordinary functions as PageStrategy.
+type PageStrategyFunc func(page *Page) (*url.URL, error)
+
+// Next implements PageStrategy.
+func (f PageStrategyFunc) Next(page *Page) (*url.URL, error) {
+	return f(page)
+}
+
+// LinkHeaderPaging returns a PageStrategy following the rel="next" links of
+// the Link headers of the responses (RFC 8288), as sent by GitHub.
+func LinkHeaderPaging() PageStrategy {
+	return PageStrategyFunc(func(page *Page) (*url.URL, error) {
+		target := linkTarget(page.Response.Header, "next")
+		if target == "" {
+			return nil, nil
+		}
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("httputil: invalid next link %q: %w", target, err)
+		}
+		return page.Request.URL.ResolveReference(u), nil
+	})
+}
+
+// PageNumberPaging returns a PageStrategy incrementing the page number query
+// parameter param, which defaults to 1. The listing ends on an empty page, or
+// once the number of items of the X-Total-Count header is seen.
+func PageNumberPaging(param string) PageStrategy {
+	return PageStrategyFunc(func(page *Page) (*url.URL, error) {
+		if totalSeen(page) {
+			return nil, nil
+		}
+		number := 1
+		if value := page.Request.URL.Query().Get(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("httputil: invalid page number %q", value)
+			}
+			number = n
+		}
+		return withQueryParam(page.Request.URL, param, strconv.Itoa(number+1)), nil
+	})
+}
+
+// OffsetPaging returns a PageStrategy incrementing the offset query parameter
+// param, which defaults to 0, by the number of items of each page. The
+// listing ends on an empty page, or once the number of items of the
+// X-Total-Count header is seen.
+func OffsetPaging(param string) PageStrategy {
+	return PageStrategyFunc(func(page *Page) (*url.URL, error) {
+		if totalSeen(page) {
+			return nil, nil
+		}
+		offset := 0
+		if value := page.Request.URL.Query().Get(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("httputil: invalid offset %q", value)
+			}
+			offset = n
+		}
+		return withQueryParam(page.Request.URL, param, strconv.Itoa(offset+page.Items)), nil
+	})
+}
+
+// CursorPaging returns a PageStrategy setting the query parameter param to
+// the cursor of the JSON body field, a dot-separated path such as
+// "next_cursor" or "meta.next". The listing ends when the cursor is missing,
+// null or empty.
+func CursorPaging(field, param string) PageStrategy {
+	return PageStrategyFunc(func(page *Page) (*url.URL, error) {
+		data, err := jsonField(page.Body, field)
+		if err != nil {
+			return nil, fmt.Errorf("httputil: cursor %s: %w", field, err)
+		}
+		var cursor string
+		data = bytes.TrimSpace(data)
+		switch {
+		case len(data) == 0 || string(data) == "null":
+		case data[0] == '"':
+			if err := json.Unmarshal(data, &cursor); err != nil {
+				return nil, fmt.Errorf("httputil: cursor %s: %w", field, err)
+			}
+		default:
+			cursor = string(data)
+		}
+		if cursor == "" {
+			return nil, nil
+		}
+		return withQueryParam(page.Request.URL, param, cursor), nil
+	})
+}
+
+// PaginateOptions paginate options
+type PaginateOptions struct {
+	strategy    PageStrategy
+	maxPages    int
+	itemsField  string
+	requestOpts []RequestOption
+}
+
+// PaginateOption paginate option
+type PaginateOption func(*PaginateOptions)
+
+// WithPageStrategy If a page strategy is set, Paginate will use it to find the next pages instead of following Link headers.
+func WithPageStrategy(strategy PageStrategy) func(*PaginateOptions) {
+	return func(options *PaginateOptions) {
+		options.strategy = strategy
+	}
+}
+
+// WithMaxPages If a maximum number of pages is set, Paginate will stop after n pages.
+func WithMaxPages(n int) func(*PaginateOptions) {
+	return func(options *PaginateOptions) {
+		options.maxPages = n
+	}
+}
+
+// WithItemsField If an items field is set, Paginate will decode the items of each page from this field of the JSON body, a dot-separated path such as "data" or "result.items", instead of the whole body.
+func WithItemsField(field string) func(*PaginateOptions) {
+	return func(options *PaginateOptions) {
+		options.itemsField = field
+	}
+}
+
+// WithPageRequestOptions If request options are set, Paginate will use them for the request of each page. Query parameters of WithQuery and URI templates of WithURITemplate only apply to the first page, the URLs of the next pages are given by the page strategy.
+func WithPageRequestOptions(opts ...RequestOption) func(*PaginateOptions) {
+	return func(options *PaginateOptions) {
+		options.requestOpts = append(options.requestOpts, opts...)
+	}
+}
+
+// Paginate returns an iterator over the items of a paginated JSON listing,
+// starting with a GET of url. Each page is decoded as a JSON array of T, see
+// WithItemsField, and the next pages are found by following Link headers,
+// see WithPageStrategy.
+//
+// The iteration stops after the last page, an empty page, a page already
+// seen, the WithMaxPages limit, or the cancellation of the context of the
+// client. A failed request, a non-2xx response or an invalid page is yielded
+// as an error, which ends the iteration.
+func Paginate[T any](c *Client, url string, opts ...PaginateOption) iter.Seq2[T, error] {
+	options := &PaginateOptions{strategy: LinkHeaderPaging()}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return func(yield func(T, error) bool) {
+		var zero T
+		pageURL, requestOpts := url, options.requestOpts
+		visited := make(map[string]bool)
+		seen := 0
+		for number := 1; options.maxPages <= 0 || number <= options.maxPages; number++ {
+			if err := c.ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			page, items, err := fetchPage[T](c, pageURL, requestOpts, options.itemsField)
+			if err != nil {
+				yield(zero, fmt.Errorf("httputil: page %d: %w", number, err))
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if len(items) == 0 {
+				return
+			}
+			seen += len(items)
+			page.Number, page.Items, page.Seen = number, len(items), seen
+			next, err := options.strategy.Next(page)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			visited[page.Request.URL.String()] = true
+			if next == nil || visited[next.String()] {
+				return
+			}
+			pageURL = next.String()
+			requestOpts = append(options.requestOpts[:len(options.requestOpts):len(options.requestOpts)], nextPageOption)
+		}
+	}
+}
+
+// nextPageOption removes the request options that would change the URLs of
+// the next pages.
+func nextPageOption(options *RequestOptions) {
+	options.query = nil
+	options.uriTemplate = false
+}
+
+// fetchPage gets the page of url and decodes its items.
+func fetchPage[T any](c *Client, url string, opts []RequestOption, itemsField string) (*Page, []T, error) {
+	resp, err := c.Get(url, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, errors.New(resp.Status)
+	}
+	data, err := jsonField(body, itemsField)
+	if err != nil {
+		return nil, nil, err
+	}
+	var items []T
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &Page{Request: resp.Request, Response: resp, Body: body}, items, nil
+}
+
+// jsonField returns the field of the JSON object data at the dot-separated
+// path, nil if it is missing, or data itself if path is empty.
+func jsonField(data []byte, path string) (json.RawMessage, error) {
+	if path == "" {
+		return data, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, err
+		}
+		var ok bool
+		if data, ok = object[key]; !ok {
+			return nil, nil
+		}
+	}
+	return data, nil
+}
+
+// totalSeen reports whether the items of the X-Total-Count header of the
+// response of page are all seen.
+func totalSeen(page *Page) bool {
+	total, err := strconv.Atoi(page.Response.Header.Get("X-Total-Count"))
+	return err == nil && page.Seen >= total
+}
+
+// withQueryParam returns u with the query parameter param set to value.
+func withQueryParam(u *url.URL, param, value string) *url.URL {
+	next := *u
+	query := next.Query()
+	query.Set(param, value)
+	next.RawQuery = query.Encode()
+	return &next
+}
+
+// linkTarget returns the target of the first link of the Link headers
+// (RFC 8288) with the relation type rel.
+func linkTarget(header http.Header, rel string) string {
+	for _, value := range header.Values("Link") {
+		for {
+			value = strings.TrimLeft(value, " \t,")
+			if !strings.HasPrefix(value, "<") {
+				break
+			}
+			end := strings.IndexByte(value, '>')
+			if end < 0 {
+				break
+			}
+			target := value[1:end]
+			value = value[end+1:]
+			matched := false
+			for {
+				value = strings.TrimLeft(value, " \t")
+				if !strings.HasPrefix(value, ";") {
+					break
+				}
+				var name, param string
+				name, param, value = parseLinkParam(value[1:])
+				if strings.EqualFold(name, "rel") {
+					for _, r := range strings.Fields(param) {
+						matched = matched || strings.EqualFold(r, rel)
+					}
+				}
+			}
+			if matched {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+// parseLinkParam parses a link parameter, returning its name, its value
+// and the rest of s.
+func parseLinkParam(s string) (name, value, rest string) {
+	i := strings.IndexAny(s, "=;,")
+	if i < 0 {
+		return strings.TrimSpace(s), "", ""
+	}
+	name = strings.TrimSpace(s[:i])
+	if s[i] != '=' {
+		return name, "", s[i:]
+	}
+	s = strings.TrimLeft(s[i+1:], " \t")
+	if !strings.HasPrefix(s, `"`) {
+		i = strings.IndexAny(s, ";,")
+		if i < 0 {
+			return name, strings.TrimSpace(s), ""
+		}
+		return name, strings.TrimSpace(s[:i]), s[i:]
+	}
+	var b strings.Builder
+	for i = 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return name, b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return name, b.String(), ""
+}
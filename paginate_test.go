@@ -0,0 +1,112 @@
+package httputil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	var items []T
+	for item, err := range seq {
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func TestPaginate(t *testing.T) {
+	const total = 7
+	// items returns the items from offset, at most 3
+	items := func(offset int) []int {
+		var items []int
+		for i := offset; i < total && i < offset+3; i++ {
+			items = append(items, i)
+		}
+		return items
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if (page+1)*3 < total {
+			w.Header().Add("Link", fmt.Sprintf(`<https://example.com/first>; rel="first", </link?page=%d&per_page=3>; title="a, b;"; rel="last next"`, page+1))
+		}
+		json.NewEncoder(w).Encode(items(page * 3))
+	})
+	mux.HandleFunc("/pages", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		json.NewEncoder(w).Encode(items((page - 1) * 3))
+	})
+	mux.HandleFunc("/offset", func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		json.NewEncoder(w).Encode(items(offset))
+	})
+	mux.HandleFunc("/cursor", func(w http.ResponseWriter, r *http.Request) {
+		cursor, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		var next any
+		if cursor+3 < total {
+			next = strconv.Itoa(cursor + 3)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": items(cursor), "meta": map[string]any{"next_cursor": next}})
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `</loop>; rel=next`)
+		json.NewEncoder(w).Encode([]int{1})
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	c := NewClient(context.Background(), WithBaseURL(ts.URL))
+	all := []int{0, 1, 2, 3, 4, 5, 6}
+
+	got, err := collect(Paginate[int](c, "/link", WithPageRequestOptions(WithHeaders(http.Header{"X-Token": {"secret"}}))))
+	assert.Nil(t, err)
+	assert.Equal(t, all, got)
+
+	got, err = collect(Paginate[int](c, "/pages", WithPageStrategy(PageNumberPaging("page")), WithPageRequestOptions(WithQuery(map[string]int{"page": 1}))))
+	assert.Nil(t, err)
+	assert.Equal(t, all, got)
+
+	got, err = collect(Paginate[int](c, "/offset", WithPageStrategy(OffsetPaging("offset"))))
+	assert.Nil(t, err)
+	assert.Equal(t, all, got)
+
+	got, err = collect(Paginate[int](c, "/cursor", WithPageStrategy(CursorPaging("meta.next_cursor", "cursor")), WithItemsField("data")))
+	assert.Nil(t, err)
+	assert.Equal(t, all, got)
+
+	got, err = collect(Paginate[int](c, "/offset", WithPageStrategy(OffsetPaging("offset")), WithMaxPages(2)))
+	assert.Nil(t, err)
+	assert.Equal(t, all[:6], got)
+
+	got, err = collect(Paginate[int](c, "/loop"))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, got)
+
+	// stopping early
+	for item := range Paginate[int](c, "/link", WithPageRequestOptions(WithHeaders(http.Header{"X-Token": {"secret"}}))) {
+		assert.Equal(t, 0, item)
+		break
+	}
+
+	_, err = collect(Paginate[int](c, "/error"))
+	assert.ErrorContains(t, err, "page 1: 410 Gone")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = collect(Paginate[int](NewClient(ctx, WithBaseURL(ts.URL)), "/link"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
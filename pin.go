@@ -0,0 +1,78 @@
+package httputil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PinMismatchError is returned when no certificate presented by a server
+// matches the public keys pinned for its host.
+type PinMismatchError struct {
+	// Host is the server name of the connection.
+	Host string
+	// Pins are the pinned SPKI hashes of Host.
+	Pins []string
+	// Presented are the SPKI hashes of the presented certificates.
+	Presented []string
+}
+
+// Error implements error.
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("httputil: public key pin mismatch for %s: presented %s", e.Host, strings.Join(e.Presented, ", "))
+}
+
+// SPKIHash returns the base64 SHA-256 hash of the SubjectPublicKeyInfo of
+// cert, as used by [WithPinnedSPKI].
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// pinnedHosts maps host names, or "*.domain" wildcards, to their pins.
+type pinnedHosts map[string][]string
+
+// lookup returns the pins of host.
+func (p pinnedHosts) lookup(host string) []string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if pins, ok := p[host]; ok {
+		return pins
+	}
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		if pins, ok := p["*."+host]; ok {
+			return pins
+		}
+	}
+	return nil
+}
+
+// verifyConnection checks the pins of the server of cs. The verified chains
+// are checked, or the presented certificates if verification is skipped.
+func (p pinnedHosts) verifyConnection(cs tls.ConnectionState) *PinMismatchError {
+	pins := p.lookup(cs.ServerName)
+	if len(pins) == 0 {
+		return nil
+	}
+	chains := cs.VerifiedChains
+	if len(chains) == 0 {
+		chains = [][]*x509.Certificate{cs.PeerCertificates}
+	}
+	var presented []string
+	for _, chain := range chains {
+		for _, cert := range chain {
+			hash := SPKIHash(cert)
+			if slices.Contains(pins, hash) {
+				return nil
+			}
+			if !slices.Contains(presented, hash) {
+				presented = append(presented, hash)
+			}
+		}
+	}
+	return &PinMismatchError{Host: cs.ServerName, Pins: pins, Presented: presented}
+}
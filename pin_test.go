@@ -0,0 +1,49 @@
+package httputil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinnedSPKI(t *testing.T) {
+	ca, server, _ := newTestPKI(t)
+	ts := newTestTLSServer(t, ca, server)
+	defer ts.Close()
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM)
+
+	// pinned CA key
+	resp, err := NewClient(context.Background(), WithRootCAs(caFile), WithServerName("example.test"), WithPinnedSPKI("example.test", SPKIHash(ca.cert))).Get(ts.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	// mismatch
+	_, err = NewClient(context.Background(), WithRootCAs(caFile), WithServerName("example.test"), WithPinnedSPKI("example.test", "AAAA")).Get(ts.URL)
+	var pinErr *PinMismatchError
+	assert.ErrorAs(t, err, &pinErr)
+	assert.Equal(t, "example.test", pinErr.Host)
+	assert.Equal(t, []string{SPKIHash(server.cert), SPKIHash(ca.cert)}, pinErr.Presented)
+
+	// report only
+	var reported *PinMismatchError
+	resp, err = NewClient(context.Background(), WithRootCAs(caFile), WithServerName("example.test"), WithPinnedSPKI("example.test", "AAAA"), WithPinReportOnly(func(err *PinMismatchError) {
+		reported = err
+	})).Get(ts.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.NotNil(t, reported)
+
+	// other hosts are not pinned
+	resp, err = NewClient(context.Background(), WithRootCAs(caFile), WithServerName("example.test"), WithPinnedSPKI("*.example.com", "AAAA")).Get(ts.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+}
+
+func TestPinnedHostsLookup(t *testing.T) {
+	pins := pinnedHosts{"example.com": {"a"}, "*.example.org": {"b"}}
+	assert.Equal(t, []string{"a"}, pins.lookup("Example.com."))
+	assert.Nil(t, pins.lookup("www.example.com"))
+	assert.Equal(t, []string{"b"}, pins.lookup("api.v1.example.org"))
+	assert.Nil(t, pins.lookup("example.org"))
+}
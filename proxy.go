@@ -0,0 +1,312 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyError is returned when a request cannot be sent through its proxy:
+// the proxy is unreachable, refuses the credentials or fails to connect to
+// the target.
+type ProxyError struct {
+	// Proxy is the proxy URL with its password redacted.
+	Proxy string
+	// StatusCode is the status of a refused CONNECT request, if any.
+	StatusCode int
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements error.
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("httputil: proxy %s: %v", e.Proxy, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// parseProxyURL parses and validates a proxy URL. Supported schemes are
+// http, https (TLS to the proxy), socks5 (target resolved locally) and
+// socks5h (target resolved by the proxy). A URL without scheme is treated as
+// http.
+func parseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("httputil: invalid proxy URL: %w", err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("httputil: unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("httputil: proxy URL %q has no host", u.Redacted())
+	}
+	return u, nil
+}
+
+// proxyChoiceKey is the context key of the proxyChoice of a request.
+type proxyChoiceKey struct{}
+
+// proxyChoice records the proxy chosen for a request by the transport.
+type proxyChoice struct {
+	proxy *url.URL
+}
+
+// proxyFunc returns a Transport.Proxy function calling selector and
+// recording its choice for proxyTransport.
+func proxyFunc(selector func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := selector(req)
+		if choice, ok := req.Context().Value(proxyChoiceKey{}).(*proxyChoice); ok {
+			choice.proxy = u
+		}
+		return u, err
+	}
+}
+
+// proxyConnectResponse is the Transport.OnProxyConnectResponse hook turning
+// refused CONNECT requests into ProxyError.
+func proxyConnectResponse(_ context.Context, proxyURL *url.URL, _ *http.Request, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	return &ProxyError{Proxy: proxyURL.Redacted(), StatusCode: resp.StatusCode, Err: errors.New(resp.Status)}
+}
+
+// proxyTransport reports failures of the proxy chosen for a request as
+// ProxyError, and the outcome of requests sent through a proxy to report.
+type proxyTransport struct {
+	next   http.RoundTripper
+	report func(proxy *url.URL, err error)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	choice := &proxyChoice{}
+	resp, err := t.next.RoundTrip(req.WithContext(context.WithValue(req.Context(), proxyChoiceKey{}, choice)))
+	if choice.proxy != nil {
+		if err != nil {
+			err = wrapProxyError(err, choice.proxy)
+		}
+		if t.report != nil {
+			t.report(choice.proxy, err)
+		}
+	}
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transport.
+func (t *proxyTransport) CloseIdleConnections() {
+	closeIdleConnections(t.next)
+}
+
+// wrapProxyError wraps err in a ProxyError if it is a failure to connect
+// through proxyURL.
+func wrapProxyError(err error, proxyURL *url.URL) error {
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		return err
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "proxyconnect" || strings.HasPrefix(opErr.Op, "socks ")) {
+		return &ProxyError{Proxy: proxyURL.Redacted(), Err: err}
+	}
+	return err
+}
+
+// socks5DialContext returns a dial function connecting through the SOCKS5
+// proxy proxyURL with forward. Target host names are resolved locally, with
+// r if not nil, and their addresses share the dial timeout.
+func socks5DialContext(proxyURL *url.URL, forward dialFunc, r *resolver, timeout time.Duration) (dialFunc, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		auth = &proxy.Auth{User: proxyURL.User.Username()}
+		auth.Password, _ = proxyURL.User.Password()
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "1080")
+	}
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, auth, forward)
+	if err != nil {
+		return nil, err
+	}
+	contextDialer := dialer.(proxy.ContextDialer)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var addrs []netip.Addr
+		if r != nil {
+			addrs, err = r.resolveDestination(ctx, host, port)
+			ctx = context.WithValue(ctx, proxyDialKey{}, true)
+		} else {
+			addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		}
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialAddrs(ctx, contextDialer.DialContext, network, addrs, port, timeout)
+		if err != nil {
+			return nil, &ProxyError{Proxy: proxyURL.Redacted(), Err: err}
+		}
+		return conn, nil
+	}, nil
+}
+
+// ProxyRule routes the requests matching one of its patterns to a proxy.
+type ProxyRule struct {
+	// Match lists the patterns of the rule:
+	//   - host names, with glob wildcards: "localhost", "*.corp.example.com"
+	//   - domains with a leading dot, matching the domain and its subdomains: ".internal"
+	//   - CIDRs matching IP hosts: "10.0.0.0/8", "fd00::/8"
+	//   - any of the above with a port: "registry.local:5000", "[::1]:8080"
+	//   - ports alone, matching any host: ":8443"
+	Match []string
+	// Proxy is the proxy URL of the matching requests. Empty sends them
+	// directly.
+	Proxy string
+}
+
+// ProxySelector selects the proxy of each request with the first matching
+// ProxyRule, or a fallback when no rule matches. Use its Proxy method with
+// WithProxyFunc.
+type ProxySelector struct {
+	rules    []proxyRule
+	fallback func(*http.Request) (*url.URL, error)
+}
+
+// proxyRule is a parsed ProxyRule.
+type proxyRule struct {
+	patterns []proxyPattern
+	proxy    *url.URL
+}
+
+// proxyPattern is a parsed ProxyRule pattern. Empty fields match anything.
+type proxyPattern struct {
+	host   string
+	domain string
+	prefix netip.Prefix
+	port   string
+}
+
+// NewProxySelector returns a ProxySelector with rules, evaluated in order.
+// Requests matching no rule use the proxy returned by fallback, e.g.
+// http.ProxyFromEnvironment; a nil fallback sends them directly.
+func NewProxySelector(fallback func(*http.Request) (*url.URL, error), rules ...ProxyRule) (*ProxySelector, error) {
+	s := &ProxySelector{fallback: fallback}
+	for _, rule := range rules {
+		var r proxyRule
+		if rule.Proxy != "" {
+			var err error
+			if r.proxy, err = parseProxyURL(rule.Proxy); err != nil {
+				return nil, err
+			}
+		}
+		for _, match := range rule.Match {
+			pattern, err := parseProxyPattern(match)
+			if err != nil {
+				return nil, err
+			}
+			r.patterns = append(r.patterns, pattern)
+		}
+		s.rules = append(s.rules, r)
+	}
+	return s, nil
+}
+
+// Proxy returns the proxy of req, or nil to send it directly.
+func (s *ProxySelector) Proxy(req *http.Request) (*url.URL, error) {
+	host := strings.TrimSuffix(strings.ToLower(req.URL.Hostname()), ".")
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr, _ := netip.ParseAddr(host)
+	for _, rule := range s.rules {
+		for _, pattern := range rule.patterns {
+			if pattern.match(host, addr, port) {
+				return rule.proxy, nil
+			}
+		}
+	}
+	if s.fallback != nil {
+		return s.fallback(req)
+	}
+	return nil, nil
+}
+
+// parseProxyPattern parses a ProxyRule pattern.
+func parseProxyPattern(pattern string) (proxyPattern, error) {
+	var p proxyPattern
+	host := strings.ToLower(strings.TrimSpace(pattern))
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return p, fmt.Errorf("httputil: invalid port in proxy pattern %q", pattern)
+		}
+		host, p.port = h, port
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	host = strings.TrimSuffix(host, ".")
+	switch {
+	case strings.Contains(host, "/"):
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return p, fmt.Errorf("httputil: invalid CIDR in proxy pattern %q", pattern)
+		}
+		p.prefix = prefix.Masked()
+	case strings.HasPrefix(host, "."):
+		p.domain = host[1:]
+	default:
+		if _, err := path.Match(host, ""); err != nil {
+			return p, fmt.Errorf("httputil: invalid proxy pattern %q", pattern)
+		}
+		p.host = host
+	}
+	if p.host == "" && p.domain == "" && !p.prefix.IsValid() && p.port == "" {
+		return p, fmt.Errorf("httputil: empty proxy pattern %q", pattern)
+	}
+	return p, nil
+}
+
+// match reports whether a request to host and port matches p. addr is host
+// parsed as an IP, if it is one.
+func (p proxyPattern) match(host string, addr netip.Addr, port string) bool {
+	if p.port != "" && p.port != port {
+		return false
+	}
+	switch {
+	case p.prefix.IsValid():
+		return addr.IsValid() && p.prefix.Contains(addr.Unmap())
+	case p.domain != "":
+		return host == p.domain || strings.HasSuffix(host, "."+p.domain)
+	case p.host != "":
+		ok, _ := path.Match(p.host, host)
+		return ok
+	}
+	return true
+}
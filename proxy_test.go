@@ -0,0 +1,260 @@
+package httputil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyHTTP(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	}))
+	defer target.Close()
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := parseProxyAuthorization(r); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxyServer.Close()
+	proxyHost := proxyServer.Listener.Addr().String()
+
+	resp, err := NewClient(context.Background(), WithProxy("http://user:pass@"+proxyHost)).Get(target.URL)
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "target", body)
+
+	resp, err = NewClient(context.Background(), WithProxy(proxyHost)).Get(target.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+}
+
+func TestProxyConnectRefused(t *testing.T) {
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	defer proxyServer.Close()
+
+	_, err := NewClient(context.Background(), WithProxy("http://user:secret@"+proxyServer.Listener.Addr().String())).Get("https://example.test/")
+	var proxyErr *ProxyError
+	assert.ErrorAs(t, err, &proxyErr)
+	assert.Equal(t, http.StatusProxyAuthRequired, proxyErr.StatusCode)
+	assert.NotContains(t, proxyErr.Proxy, "secret")
+}
+
+func TestProxyErrors(t *testing.T) {
+	c := NewClient(context.Background(), WithProxy("ftp://proxy.test"))
+	assert.NotNil(t, c.Err())
+	_, err := c.Get("http://example.test/")
+	assert.Equal(t, c.Err(), err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	for _, scheme := range []string{"http", "socks5", "socks5h"} {
+		_, err = NewClient(context.Background(), WithProxy(scheme+"://"+addr)).Get("http://127.0.0.1/")
+		var proxyErr *ProxyError
+		assert.ErrorAs(t, err, &proxyErr, scheme)
+	}
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	}))
+	defer target.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	dests := make(chan string, 2)
+	go serveTestSOCKS5(l, "user", "pass", dests)
+
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		resp, err := NewClient(context.Background(), WithProxy(scheme+"://user:pass@"+l.Addr().String())).Get(target.URL)
+		assert.Nil(t, err, scheme)
+		body, _ := ReadString(resp)
+		assert.Equal(t, "target", body)
+		assert.Equal(t, target.Listener.Addr().String(), <-dests)
+	}
+}
+
+func TestProxySelector(t *testing.T) {
+	fallback := func(*http.Request) (*url.URL, error) {
+		return url.Parse("http://fallback:3128")
+	}
+	s, err := NewProxySelector(fallback,
+		ProxyRule{Match: []string{"localhost", "*.corp.example.com", ".internal", "10.0.0.0/8", "[::1]:8080"}},
+		ProxyRule{Match: []string{":8443", "registry.test:5000"}, Proxy: "socks5h://socks:1080"},
+		ProxyRule{Match: []string{"*"}, Proxy: "http://proxy:3128"},
+	)
+	assert.Nil(t, err)
+	for target, want := range map[string]string{
+		"http://localhost/":             "",
+		"https://a.b.corp.example.com/": "",
+		"http://corp.example.com/":      "http://proxy:3128",
+		"http://internal/":              "",
+		"http://db.internal./":          "",
+		"http://10.1.2.3:9000/":         "",
+		"http://11.1.2.3/":              "http://proxy:3128",
+		"http://[::1]:8080/":            "",
+		"http://[::1]/":                 "http://proxy:3128",
+		"https://example.com:8443/":     "socks5h://socks:1080",
+		"http://registry.test:5000/v2/": "socks5h://socks:1080",
+		"http://registry.test/v2/":      "http://proxy:3128",
+		"https://Example.COM/":          "http://proxy:3128",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		u, err := s.Proxy(req)
+		assert.Nil(t, err)
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		assert.Equal(t, want, got, target)
+	}
+
+	s, err = NewProxySelector(fallback, ProxyRule{Match: []string{"10.0.0.0/8"}, Proxy: "http://proxy:3128"})
+	assert.Nil(t, err)
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	u, err := s.Proxy(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://fallback:3128", u.String())
+
+	for _, rule := range []ProxyRule{
+		{Match: []string{"10.0.0.0/33"}},
+		{Match: []string{"host:port"}},
+		{Match: []string{"[a-"}},
+		{Match: []string{""}},
+		{Match: []string{"*"}, Proxy: "ftp://proxy"},
+	} {
+		_, err = NewProxySelector(nil, rule)
+		assert.NotNil(t, err, rule.Match)
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	}))
+	defer target.Close()
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxy"))
+	}))
+	defer proxyServer.Close()
+
+	s, err := NewProxySelector(nil, ProxyRule{Match: []string{"direct.test"}}, ProxyRule{Match: []string{"*"}, Proxy: proxyServer.URL})
+	assert.Nil(t, err)
+	c := NewClient(context.Background(), WithProxyFunc(s.Proxy))
+	resp, err := c.Get(target.URL)
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "proxy", body)
+
+	s, err = NewProxySelector(nil, ProxyRule{Match: []string{"127.0.0.0/8"}}, ProxyRule{Match: []string{"*"}, Proxy: proxyServer.URL})
+	assert.Nil(t, err)
+	resp, err = NewClient(context.Background(), WithProxyFunc(s.Proxy)).Get(target.URL)
+	assert.Nil(t, err)
+	body, _ = ReadString(resp)
+	assert.Equal(t, "target", body)
+
+	// selector errors fail the request
+	selectErr := errors.New("no proxy")
+	_, err = NewClient(context.Background(), WithProxyFunc(func(*http.Request) (*url.URL, error) {
+		return nil, selectErr
+	})).Get(target.URL)
+	assert.ErrorIs(t, err, selectErr)
+}
+
+// parseProxyAuthorization returns the basic credentials of the
+// Proxy-Authorization header of r.
+func parseProxyAuthorization(r *http.Request) (user, pass string, ok bool) {
+	req := &http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}
+	return req.BasicAuth()
+}
+
+// serveTestSOCKS5 serves a minimal SOCKS5 proxy requiring username/password
+// authentication, sending the destination of each connection to dests.
+func serveTestSOCKS5(l net.Listener, user, pass string, dests chan<- string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			buf := make([]byte, 262)
+			// greeting
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+				return
+			}
+			conn.Write([]byte{5, 2})
+			// username/password
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			u := make([]byte, buf[1])
+			io.ReadFull(conn, u)
+			io.ReadFull(conn, buf[:1])
+			p := make([]byte, buf[0])
+			io.ReadFull(conn, p)
+			if string(u) != user || string(p) != pass {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+			// connect
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+				return
+			}
+			var host string
+			switch buf[3] {
+			case 1:
+				io.ReadFull(conn, buf[:4])
+				host = net.IP(buf[:4]).String()
+			case 3:
+				io.ReadFull(conn, buf[:1])
+				name := make([]byte, buf[0])
+				io.ReadFull(conn, name)
+				host = string(name)
+			case 4:
+				io.ReadFull(conn, buf[:16])
+				host = net.IP(buf[:16]).String()
+			}
+			io.ReadFull(conn, buf[:2])
+			dest := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+			dests <- dest
+			upstream, err := net.Dial("tcp", dest)
+			if err != nil {
+				conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer upstream.Close()
+			conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+			go io.Copy(upstream, conn)
+			io.Copy(conn, upstream)
+		}()
+	}
+}
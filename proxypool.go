@@ -0,0 +1,211 @@
+package httputil
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoHealthyProxy is returned when every proxy of a ProxyPool is unhealthy.
+var ErrNoHealthyProxy = errors.New("httputil: no healthy proxy")
+
+// ProxyStrategy is how a ProxyPool picks the proxy of a request.
+type ProxyStrategy int
+
+const (
+	// ProxyRoundRobin uses the healthy proxies in turn.
+	ProxyRoundRobin ProxyStrategy = iota
+	// ProxyRandom uses a random healthy proxy.
+	ProxyRandom
+	// ProxyLeastFailures uses the healthy proxy with the fewest failures.
+	ProxyLeastFailures
+	// ProxyStickyHost uses the same proxy for every request to a host while
+	// it is healthy, assigning proxies to hosts in turn.
+	ProxyStickyHost
+)
+
+// ProxyPoolConfig configures a ProxyPool.
+type ProxyPoolConfig struct {
+	// Proxies are the proxy URLs of the pool, see WithProxy.
+	Proxies []string
+	// Strategy is how proxies are picked. Defaults to ProxyRoundRobin.
+	Strategy ProxyStrategy
+	// MaxFailures is the number of consecutive failures after which a proxy
+	// is unhealthy. Defaults to 3.
+	MaxFailures int
+	// RecoveryTime is how long an unhealthy proxy is skipped before it is
+	// tried again. Defaults to 30 seconds.
+	RecoveryTime time.Duration
+}
+
+// ProxyStats are the statistics of a proxy of a ProxyPool.
+type ProxyStats struct {
+	// Proxy is the proxy URL with its password redacted.
+	Proxy string
+	// Requests is the number of requests sent through the proxy.
+	Requests int64
+	// Failures is the number of requests that failed with a ProxyError.
+	Failures int64
+	// ConsecutiveFailures is the number of failures since the last success.
+	ConsecutiveFailures int
+	// Healthy reports whether the proxy is currently used.
+	Healthy bool
+	// LastError is the last ProxyError of the proxy.
+	LastError error
+	// LastFailure is the time of the last failure.
+	LastFailure time.Time
+}
+
+// ProxyPool distributes requests over several proxies, see WithProxyPool.
+//
+// A proxy failing MaxFailures times in a row with a ProxyError is unhealthy
+// and skipped for RecoveryTime; it is then tried again, and a success makes
+// it healthy. Other errors, such as timeouts of the target, do not count.
+type ProxyPool struct {
+	config ProxyPoolConfig
+
+	mu      sync.Mutex
+	proxies []*poolProxy
+	next    int
+	sticky  map[string]*poolProxy
+	now     func() time.Time
+}
+
+// poolProxy is a proxy of a ProxyPool and its statistics.
+type poolProxy struct {
+	url   *url.URL
+	stats ProxyStats
+}
+
+// NewProxyPool returns a ProxyPool with config.
+func NewProxyPool(config ProxyPoolConfig) (*ProxyPool, error) {
+	if len(config.Proxies) == 0 {
+		return nil, errors.New("httputil: proxy pool has no proxies")
+	}
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = 3
+	}
+	if config.RecoveryTime <= 0 {
+		config.RecoveryTime = 30 * time.Second
+	}
+	p := &ProxyPool{config: config, sticky: make(map[string]*poolProxy)}
+	for _, raw := range config.Proxies {
+		u, err := parseProxyURL(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, err
+		}
+		p.proxies = append(p.proxies, &poolProxy{url: u, stats: ProxyStats{Proxy: u.Redacted()}})
+	}
+	return p, nil
+}
+
+// Proxy returns the proxy of req, or ErrNoHealthyProxy. It can be used with
+// WithProxyFunc, but WithProxyPool also reports the outcome of requests.
+func (p *ProxyPool) Proxy(req *http.Request) (*url.URL, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.time()
+	var proxy *poolProxy
+	switch p.config.Strategy {
+	case ProxyRandom:
+		healthy := make([]*poolProxy, 0, len(p.proxies))
+		for _, proxy := range p.proxies {
+			if p.available(proxy, now) {
+				healthy = append(healthy, proxy)
+			}
+		}
+		if len(healthy) > 0 {
+			proxy = healthy[rand.IntN(len(healthy))]
+		}
+	case ProxyLeastFailures:
+		for i := range p.proxies {
+			candidate := p.proxies[(p.next+i)%len(p.proxies)]
+			if p.available(candidate, now) && (proxy == nil || candidate.stats.Failures < proxy.stats.Failures) {
+				proxy = candidate
+			}
+		}
+		p.next++
+	case ProxyStickyHost:
+		host := strings.ToLower(req.URL.Host)
+		if proxy = p.sticky[host]; proxy == nil || !p.available(proxy, now) {
+			if proxy = p.roundRobin(now); proxy != nil {
+				p.sticky[host] = proxy
+			}
+		}
+	default:
+		proxy = p.roundRobin(now)
+	}
+	if proxy == nil {
+		return nil, ErrNoHealthyProxy
+	}
+	proxy.stats.Requests++
+	return proxy.url, nil
+}
+
+// Stats returns the statistics of the proxies of the pool, in the order of
+// ProxyPoolConfig.Proxies.
+func (p *ProxyPool) Stats() []ProxyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.time()
+	stats := make([]ProxyStats, len(p.proxies))
+	for i, proxy := range p.proxies {
+		stats[i] = proxy.stats
+		stats[i].Healthy = p.available(proxy, now)
+	}
+	return stats
+}
+
+// report records the outcome of a request sent through proxyURL.
+func (p *ProxyPool) report(proxyURL *url.URL, err error) {
+	var proxyErr *ProxyError
+	if err != nil && !errors.As(err, &proxyErr) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, proxy := range p.proxies {
+		if proxy.url != proxyURL {
+			continue
+		}
+		if err == nil {
+			proxy.stats.ConsecutiveFailures = 0
+			return
+		}
+		proxy.stats.Failures++
+		proxy.stats.ConsecutiveFailures++
+		proxy.stats.LastError = err
+		proxy.stats.LastFailure = p.time()
+		return
+	}
+}
+
+// roundRobin returns the next available proxy.
+func (p *ProxyPool) roundRobin(now time.Time) *poolProxy {
+	for range p.proxies {
+		proxy := p.proxies[p.next%len(p.proxies)]
+		p.next++
+		if p.available(proxy, now) {
+			return proxy
+		}
+	}
+	return nil
+}
+
+// available reports whether proxy is healthy or its recovery time elapsed.
+func (p *ProxyPool) available(proxy *poolProxy, now time.Time) bool {
+	return proxy.stats.ConsecutiveFailures < p.config.MaxFailures ||
+		!now.Before(proxy.stats.LastFailure.Add(p.config.RecoveryTime))
+}
+
+// time returns the current time.
+func (p *ProxyPool) time() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,132 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyPoolStrategies(t *testing.T) {
+	proxies := []string{"http://a:3128", "http://b:3128", "socks5h://c:1080"}
+	pick := func(pool *ProxyPool, target string) string {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		u, err := pool.Proxy(req)
+		assert.Nil(t, err)
+		return u.Hostname()
+	}
+
+	pool, err := NewProxyPool(ProxyPoolConfig{Proxies: proxies})
+	assert.Nil(t, err)
+	var got []string
+	for range 4 {
+		got = append(got, pick(pool, "http://example.com/"))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a"}, got)
+
+	pool, err = NewProxyPool(ProxyPoolConfig{Proxies: proxies, Strategy: ProxyStickyHost})
+	assert.Nil(t, err)
+	assert.Equal(t, "a", pick(pool, "http://one.test/"))
+	assert.Equal(t, "b", pick(pool, "http://two.test/"))
+	assert.Equal(t, "a", pick(pool, "http://ONE.test/x"))
+	assert.Equal(t, "b", pick(pool, "http://two.test/y"))
+
+	pool, err = NewProxyPool(ProxyPoolConfig{Proxies: proxies, Strategy: ProxyLeastFailures, MaxFailures: 10})
+	assert.Nil(t, err)
+	proxyErr := &ProxyError{Err: errors.New("refused")}
+	pool.report(pool.proxies[0].url, proxyErr)
+	pool.report(pool.proxies[2].url, proxyErr)
+	for range 3 {
+		assert.Equal(t, "b", pick(pool, "http://example.com/"))
+	}
+
+	pool, err = NewProxyPool(ProxyPoolConfig{Proxies: proxies, Strategy: ProxyRandom})
+	assert.Nil(t, err)
+	seen := map[string]bool{}
+	for range 100 {
+		seen[pick(pool, "http://example.com/")] = true
+	}
+	assert.Len(t, seen, 3)
+
+	_, err = NewProxyPool(ProxyPoolConfig{})
+	assert.NotNil(t, err)
+	_, err = NewProxyPool(ProxyPoolConfig{Proxies: []string{"ftp://a"}})
+	assert.NotNil(t, err)
+}
+
+func TestProxyPoolHealth(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pool, err := NewProxyPool(ProxyPoolConfig{Proxies: []string{"http://a:3128", "http://b:3128"}, MaxFailures: 2, RecoveryTime: time.Minute})
+	assert.Nil(t, err)
+	pool.now = func() time.Time { return now }
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	a := pool.proxies[0].url
+	proxyErr := &ProxyError{Err: errors.New("refused")}
+
+	// other errors are ignored, successes reset consecutive failures
+	pool.report(a, proxyErr)
+	pool.report(a, errors.New("target timeout"))
+	pool.report(a, nil)
+	pool.report(a, proxyErr)
+	assert.True(t, pool.Stats()[0].Healthy)
+	pool.report(a, proxyErr)
+	stats := pool.Stats()[0]
+	assert.False(t, stats.Healthy)
+	assert.Equal(t, int64(3), stats.Failures)
+	assert.Equal(t, 2, stats.ConsecutiveFailures)
+	assert.Equal(t, proxyErr, stats.LastError)
+	assert.Equal(t, now, stats.LastFailure)
+
+	for range 3 {
+		u, err := pool.Proxy(req)
+		assert.Nil(t, err)
+		assert.Equal(t, "b", u.Hostname())
+	}
+	pool.report(pool.proxies[1].url, proxyErr)
+	pool.report(pool.proxies[1].url, proxyErr)
+	_, err = pool.Proxy(req)
+	assert.ErrorIs(t, err, ErrNoHealthyProxy)
+
+	// recovery
+	now = now.Add(time.Minute)
+	u, err := pool.Proxy(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", u.Hostname())
+	pool.report(a, nil)
+	assert.True(t, pool.Stats()[0].Healthy)
+	assert.Equal(t, int64(1), pool.Stats()[0].Requests)
+}
+
+func TestWithProxyPool(t *testing.T) {
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxy"))
+	}))
+	defer proxyServer.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	dead := "http://" + l.Addr().String()
+	l.Close()
+
+	pool, err := NewProxyPool(ProxyPoolConfig{Proxies: []string{dead, proxyServer.URL}, MaxFailures: 1})
+	assert.Nil(t, err)
+	c := NewClient(context.Background(), WithProxyPool(pool))
+	_, err = c.Get("http://example.test/")
+	var proxyErr *ProxyError
+	assert.ErrorAs(t, err, &proxyErr)
+	for range 3 {
+		resp, err := c.Get("http://example.test/")
+		assert.Nil(t, err)
+		body, _ := ReadString(resp)
+		assert.Equal(t, "proxy", body)
+	}
+	stats := pool.Stats()
+	assert.False(t, stats[0].Healthy)
+	assert.Equal(t, int64(1), stats[0].Requests)
+	assert.True(t, stats[1].Healthy)
+	assert.Equal(t, int64(3), stats[1].Requests)
+}
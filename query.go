@@ -0,0 +1,376 @@
+package httputil
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryMarshaler is implemented by types that encode themselves as the query
+// parameter key, or as parameters derived from key, see WithQuery.
+type QueryMarshaler interface {
+	MarshalQuery(key string, values url.Values) error
+}
+
+var (
+	queryMarshalerType = reflect.TypeFor[QueryMarshaler]()
+	textMarshalerType  = reflect.TypeFor[encoding.TextMarshaler]()
+	timeType           = reflect.TypeFor[time.Time]()
+	readerType         = reflect.TypeFor[io.Reader]()
+	uploadFileType     = reflect.TypeFor[UploadFile]()
+)
+
+// fieldOptions are the options of a struct field tag.
+type fieldOptions struct {
+	omitEmpty bool
+	comma     bool
+	layout    string
+}
+
+// valuesEncoder encodes structs and maps as url.Values using the struct tags
+// named tag, and collects their files if it has a files list.
+type valuesEncoder struct {
+	tag    string
+	values url.Values
+	files  *[]*UploadFile
+}
+
+// encodeValues encodes v, a struct, a pointer to a struct, a map or
+// url.Values, as url.Values using the struct tags named tag.
+func encodeValues(v any, tag string) (url.Values, error) {
+	e := &valuesEncoder{tag: tag}
+	if err := e.encode(v); err != nil {
+		return nil, err
+	}
+	return e.values, nil
+}
+
+// encode encodes v into the values of e.
+func (e *valuesEncoder) encode(v any) error {
+	if values, ok := v.(url.Values); ok {
+		e.values = values
+		return nil
+	}
+	e.values = make(url.Values)
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		return e.encodeStruct("", rv)
+	case reflect.Map:
+		return e.encodeValue("", rv, fieldOptions{})
+	}
+	return fmt.Errorf("httputil: cannot encode %T as %s values", v, e.tag)
+}
+
+// encodeStruct encodes the fields of the struct v, with names nested in
+// prefix.
+func (e *valuesEncoder) encodeStruct(prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		name, opts, ok := parseFieldTag(field, e.tag)
+		if !ok {
+			continue
+		}
+		fv := v.Field(i)
+		if field.Anonymous && name == "" {
+			// the fields of embedded structs are promoted
+			for fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Pointer && fv.Type().Elem().Kind() == reflect.Struct {
+				continue
+			}
+			if fv.Kind() == reflect.Struct && isNested(fv.Type()) {
+				if err := e.encodeStruct(prefix, fv); err != nil {
+					return err
+				}
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			fv = v.Field(i)
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if err := e.encodeValue(nestedKey(prefix, name), fv, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseFieldTag returns the name and options of the tag of field, or false
+// if the field is skipped.
+func parseFieldTag(field reflect.StructField, tag string) (string, fieldOptions, bool) {
+	value := field.Tag.Get(tag)
+	if value == "-" {
+		return "", fieldOptions{}, false
+	}
+	name, rest, _ := strings.Cut(value, ",")
+	opts := fieldOptions{layout: field.Tag.Get("layout")}
+	for _, opt := range strings.Split(rest, ",") {
+		switch opt {
+		case "omitempty":
+			opts.omitEmpty = true
+		case "comma":
+			opts.comma = true
+		}
+	}
+	return name, opts, true
+}
+
+// encodeValue adds v as the values of key.
+func (e *valuesEncoder) encodeValue(key string, v reflect.Value, opts fieldOptions) error {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if file, ok, err := e.file(key, v); ok || err != nil {
+		if file != nil {
+			*e.files = append(*e.files, file)
+		}
+		return err
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			if !opts.omitEmpty {
+				e.values.Add(key, "")
+			}
+			return nil
+		}
+		if v.Type().Implements(queryMarshalerType) {
+			break
+		}
+		// a pointer to a zero value is not empty
+		v = v.Elem()
+		opts.omitEmpty = false
+	}
+	if opts.omitEmpty && isEmptyValue(v) {
+		return nil
+	}
+	if m, ok := asInterface[QueryMarshaler](v, queryMarshalerType); ok {
+		return m.MarshalQuery(key, e.values)
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if implements(v.Type(), textMarshalerType) {
+			break
+		}
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			e.values.Add(key, string(v.Bytes()))
+			return nil
+		}
+		var joined []string
+		for i := range v.Len() {
+			elem := v.Index(i)
+			// structs and maps are indexed, e.g. "a[0][b]"
+			elemKey := key
+			if isNested(elem.Type()) {
+				elemKey = nestedKey(key, strconv.Itoa(i))
+			}
+			if opts.comma {
+				s, err := formatValue(elem, opts)
+				if err != nil {
+					return fmt.Errorf("httputil: %s: %w", key, err)
+				}
+				joined = append(joined, s)
+				continue
+			}
+			if err := e.encodeValue(elemKey, elem, fieldOptions{layout: opts.layout}); err != nil {
+				return err
+			}
+		}
+		if opts.comma && len(joined) > 0 {
+			e.values.Add(key, strings.Join(joined, ","))
+		}
+		return nil
+	case reflect.Map:
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+		})
+		for _, k := range keys {
+			if err := e.encodeValue(nestedKey(key, fmt.Sprint(k.Interface())), v.MapIndex(k), fieldOptions{layout: opts.layout}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		if isNested(v.Type()) {
+			return e.encodeStruct(key, v)
+		}
+	}
+	s, err := formatValue(v, opts)
+	if err != nil {
+		return fmt.Errorf("httputil: %s: %w", key, err)
+	}
+	e.values.Add(key, s)
+	return nil
+}
+
+// file returns the file part of v when v is an UploadFile, an *os.File or
+// another io.Reader.
+func (e *valuesEncoder) file(key string, v reflect.Value) (*UploadFile, bool, error) {
+	var file *UploadFile
+	switch {
+	case !v.IsValid():
+		return nil, false, nil
+	case v.Type() == uploadFileType:
+		upload := v.Interface().(UploadFile)
+		file = &upload
+	case v.Type() == reflect.PointerTo(uploadFileType):
+		if v.IsNil() {
+			return nil, true, nil
+		}
+		upload := *v.Interface().(*UploadFile)
+		file = &upload
+	case v.Type().Implements(readerType):
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil, true, nil
+		}
+		reader := v.Interface().(io.Reader)
+		file = &UploadFile{FileName: key, Body: reader}
+		if named, ok := reader.(interface{ Name() string }); ok {
+			file.FileName = filepath.Base(named.Name())
+		}
+	default:
+		return nil, false, nil
+	}
+	if e.files == nil {
+		return nil, true, fmt.Errorf("httputil: %s: files require a multipart form", key)
+	}
+	file.FieldName = key
+	if file.FileName == "" {
+		file.FileName = key
+	}
+	return file, true, nil
+}
+
+// formatValue formats the scalar v.
+func formatValue(v reflect.Value, opts fieldOptions) (string, error) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() == timeType {
+		return formatTime(v.Interface().(time.Time), opts.layout), nil
+	}
+	if m, ok := asInterface[encoding.TextMarshaler](v, textMarshalerType); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct, reflect.Func, reflect.Chan:
+		return "", fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return fmt.Sprint(v.Interface()), nil
+}
+
+// formatTime formats t with layout: a time layout, "unix" or "unixmilli".
+// The default layout is RFC 3339.
+func formatTime(t time.Time, layout string) string {
+	switch layout {
+	case "":
+		return t.Format(time.RFC3339)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixmilli":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return t.Format(layout)
+}
+
+// isNested reports whether the values of t are encoded as nested keys.
+func isNested(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return !implements(t, queryMarshalerType)
+	case reflect.Struct:
+		return t != timeType && t != uploadFileType && !implements(t, queryMarshalerType, textMarshalerType, readerType)
+	}
+	return false
+}
+
+// nestedKey returns the key of name nested in prefix, e.g. "a[b]".
+func nestedKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "[" + name + "]"
+}
+
+// isEmptyValue reports whether v is empty for the omitempty option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// implements reports whether t or *t implements one of the interfaces.
+func implements(t reflect.Type, interfaces ...reflect.Type) bool {
+	for _, iface := range interfaces {
+		if t.Implements(iface) || reflect.PointerTo(t).Implements(iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// asInterface returns v as the interface T, using a pointer to a copy of v if
+// only *v implements it.
+func asInterface[T any](v reflect.Value, iface reflect.Type) (T, bool) {
+	var zero T
+	if !v.IsValid() {
+		return zero, false
+	}
+	if v.Type().Implements(iface) {
+		return v.Interface().(T), true
+	}
+	if reflect.PointerTo(v.Type()).Implements(iface) {
+		if v.CanAddr() {
+			return v.Addr().Interface().(T), true
+		}
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface().(T), true
+	}
+	return zero, false
+}
@@ -0,0 +1,227 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSort struct {
+	field string
+	desc  bool
+}
+
+func (s testSort) MarshalQuery(key string, values url.Values) error {
+	order := "asc"
+	if s.desc {
+		order = "desc"
+	}
+	values.Set(key, s.field+":"+order)
+	return nil
+}
+
+type testPage struct {
+	Page    int `url:"page,omitempty"`
+	PerPage int `url:"per_page,omitempty"`
+}
+
+type testListParams struct {
+	testPage
+	Query   string            `url:"q"`
+	Tags    []string          `url:"tag,omitempty"`
+	IDs     []int             `url:"ids,comma,omitempty"`
+	Since   time.Time         `url:"since,omitempty" layout:"2006-01-02"`
+	Until   time.Time         `url:"until,omitempty" layout:"unix"`
+	Created time.Time         `url:"created,omitempty"`
+	Active  *bool             `url:"active,omitempty"`
+	Sort    testSort          `url:"sort"`
+	IP      net.IP            `url:"ip,omitempty"`
+	Filter  map[string]string `url:"filter,omitempty"`
+	Range   struct {
+		Min float64 `url:"min"`
+		Max float64 `url:"max,omitempty"`
+	} `url:"range"`
+	Ignored string `url:"-"`
+	Name    string
+	secret  string
+}
+
+func TestEncodeQuery(t *testing.T) {
+	active := false
+	params := testListParams{
+		testPage: testPage{Page: 2},
+		Query:    "a b",
+		Tags:     []string{"x", "y"},
+		IDs:      []int{1, 2, 3},
+		Since:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Until:    time.Unix(1700000000, 0),
+		Active:   &active,
+		Sort:     testSort{field: "name", desc: true},
+		IP:       net.ParseIP("192.0.2.1"),
+		Filter:   map[string]string{"b": "2", "a": "1"},
+		Ignored:  "x",
+		Name:     "n",
+		secret:   "s",
+	}
+	params.Range.Min = 1.5
+	values, err := encodeValues(&params, "url")
+	assert.Nil(t, err)
+	assert.Equal(t, url.Values{
+		"page":       {"2"},
+		"q":          {"a b"},
+		"tag":        {"x", "y"},
+		"ids":        {"1,2,3"},
+		"since":      {"2026-01-02"},
+		"until":      {"1700000000"},
+		"active":     {"false"},
+		"sort":       {"name:desc"},
+		"ip":         {"192.0.2.1"},
+		"filter[a]":  {"1"},
+		"filter[b]":  {"2"},
+		"range[min]": {"1.5"},
+		"Name":       {"n"},
+	}, values)
+
+	values, err = encodeValues(map[string]any{"a": 1, "b": []string{"x", "y"}}, "url")
+	assert.Nil(t, err)
+	assert.Equal(t, url.Values{"a": {"1"}, "b": {"x", "y"}}, values)
+
+	_, err = encodeValues("a=b", "url")
+	assert.NotNil(t, err)
+	_, err = encodeValues(struct {
+		F func() `url:"f,comma"`
+	}{F: func() {}}, "url")
+	assert.NotNil(t, err)
+}
+
+func TestWithQuery(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer ts.Close()
+
+	c := NewClient(context.Background(), WithDefaultQuery(url.Values{"key": {"k"}, "page": {"9"}}))
+	resp, err := c.Get(ts.URL+"?page=1&keep=1", WithQuery(testPage{Page: 2, PerPage: 10}))
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "keep=1&page=2&per_page=10&key=k", body)
+
+	resp, err = c.Delete(ts.URL, WithQuery(url.Values{"id": {"1"}}), WithQuery(map[string]int{"id": 2}))
+	assert.Nil(t, err)
+	body, _ = ReadString(resp)
+	assert.Equal(t, "id=2&key=k&page=9", body)
+
+	// the query of the URL is kept as it is written
+	resp, err = c.Get(ts.URL + "?b=1&a&c=%2F")
+	assert.Nil(t, err)
+	body, _ = ReadString(resp)
+	assert.Equal(t, "b=1&a&c=%2F&key=k&page=9", body)
+
+	_, err = c.Post(ts.URL, "text/plain", strings.NewReader("body"), WithQuery(42))
+	assert.NotNil(t, err)
+}
+
+func TestPostFormStruct(t *testing.T) {
+	type address struct {
+		Street string `form:"street"`
+		City   string `form:"city,omitempty"`
+	}
+	type user struct {
+		Name    string    `form:"name"`
+		Roles   []string  `form:"roles[]"`
+		Address address   `form:"address"`
+		Phones  []address `form:"phones"`
+		Meta    map[string]map[string]int
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		w.Write([]byte(r.PostForm.Encode()))
+	}))
+	defer ts.Close()
+
+	resp, err := NewClient(context.Background()).PostFormStruct(ts.URL, user{
+		Name:    "a",
+		Roles:   []string{"admin", "dev"},
+		Address: address{Street: "s"},
+		Phones:  []address{{Street: "p0"}, {Street: "p1", City: "c1"}},
+		Meta:    map[string]map[string]int{"a": {"b": 1}},
+	})
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	values, _ := url.ParseQuery(body)
+	assert.Equal(t, url.Values{
+		"name":              {"a"},
+		"roles[]":           {"admin", "dev"},
+		"address[street]":   {"s"},
+		"phones[0][street]": {"p0"},
+		"phones[1][street]": {"p1"},
+		"phones[1][city]":   {"c1"},
+		"Meta[a][b]":        {"1"},
+	}, values)
+
+	_, err = PostFormStruct(ts.URL, struct {
+		File io.Reader `form:"file"`
+	}{File: strings.NewReader("data")})
+	assert.NotNil(t, err)
+}
+
+func TestPostMultipartStruct(t *testing.T) {
+	type upload struct {
+		Title  string     `form:"title"`
+		Avatar *os.File   `form:"avatar"`
+		Doc    UploadFile `form:"doc"`
+		Blob   io.Reader  `form:"blob"`
+		Extra  io.Reader  `form:"extra"`
+		Nested struct {
+			Attachment any `form:"attachment"`
+		} `form:"post"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseMultipartForm(1<<20))
+		var lines []string
+		for key, values := range r.MultipartForm.Value {
+			lines = append(lines, key+"="+strings.Join(values, ","))
+		}
+		for key, files := range r.MultipartForm.File {
+			f, _ := files[0].Open()
+			data, _ := io.ReadAll(f)
+			f.Close()
+			lines = append(lines, key+":"+files[0].Filename+"="+string(data))
+		}
+		slices.Sort(lines)
+		w.Write([]byte(strings.Join(lines, "\n")))
+	}))
+	defer ts.Close()
+
+	f, err := os.Open(writeTestFile(t, "avatar.png", []byte("png")))
+	assert.Nil(t, err)
+	defer f.Close()
+
+	var v upload
+	v.Title = "t"
+	v.Avatar = f
+	v.Doc = UploadFile{FileName: "doc.txt", Body: strings.NewReader("doc")}
+	v.Blob = bytes.NewBufferString("blob")
+	v.Nested.Attachment = &UploadFile{FileName: "a.txt", Body: strings.NewReader("a")}
+	resp, err := NewClient(context.Background()).PostMultipartStruct(ts.URL, &v)
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, strings.Join([]string{
+		"avatar:avatar.png=png",
+		"blob:blob=blob",
+		"doc:doc.txt=doc",
+		"post[attachment]:a.txt=a",
+		"title=t",
+	}, "\n"), body)
+}
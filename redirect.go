@@ -0,0 +1,78 @@
+package httputil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+var (
+	// ErrTooManyRedirects is returned when a request exceeds the maximum number of redirects.
+	ErrTooManyRedirects = errors.New("httputil: too many redirects")
+	// ErrRedirectOffHost is returned when a request is redirected to another host with WithSameHostRedirects.
+	ErrRedirectOffHost = errors.New("httputil: redirect to another host")
+	// ErrRedirectDowngrade is returned when a request is redirected from https to http with WithNoHTTPSDowngrade.
+	ErrRedirectDowngrade = errors.New("httputil: redirect from https to http")
+)
+
+// defaultMaxRedirects is the maximum number of redirects of net/http.
+const defaultMaxRedirects = 10
+
+// redirectPolicy decides whether the redirects of a client are followed.
+type redirectPolicy struct {
+	max         int
+	none        bool
+	sameHost    bool
+	noDowngrade bool
+	check       func(req *http.Request, via []*http.Request) error
+}
+
+// redirectPolicy returns the redirect policy of options, creating it.
+func (options *ClientOptions) redirectPolicy() *redirectPolicy {
+	if options.redirects == nil {
+		options.redirects = &redirectPolicy{max: defaultMaxRedirects}
+	}
+	return options.redirects
+}
+
+// checkRedirect implements http.Client.CheckRedirect.
+func (p *redirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if p.none {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > p.max {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrTooManyRedirects, p.max)
+	}
+	if p.sameHost && !sameHost(via[0].URL, req.URL) {
+		return fmt.Errorf("%w: %s", ErrRedirectOffHost, req.URL.Redacted())
+	}
+	if p.noDowngrade && via[len(via)-1].URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: %s", ErrRedirectDowngrade, req.URL.Redacted())
+	}
+	if p.check != nil {
+		return p.check(req, via)
+	}
+	return nil
+}
+
+// Redirect is a hop of a redirect chain.
+type Redirect struct {
+	// URL is the requested URL.
+	URL string
+	// StatusCode is the status code of the response.
+	StatusCode int
+}
+
+// RedirectChain returns the requests that led to resp, from the original
+// request to the request of resp, with the status codes of their responses.
+// A response that was not redirected has a chain of one hop.
+func RedirectChain(resp *http.Response) []Redirect {
+	var chain []Redirect
+	for resp != nil && resp.Request != nil {
+		chain = append(chain, Redirect{URL: resp.Request.URL.String(), StatusCode: resp.StatusCode})
+		resp = resp.Request.Response
+	}
+	slices.Reverse(chain)
+	return chain
+}
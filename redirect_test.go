@@ -0,0 +1,88 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirects(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.URL.Query().Get("to"); target != "" {
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/r/"))
+		if n > 0 {
+			http.Redirect(w, r, "/r/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		w.Write([]byte("done"))
+	}))
+	defer ts.Close()
+
+	resp, err := NewClient(context.Background()).Get(ts.URL + "/r/3")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []Redirect{
+		{URL: ts.URL + "/r/3", StatusCode: http.StatusFound},
+		{URL: ts.URL + "/r/2", StatusCode: http.StatusFound},
+		{URL: ts.URL + "/r/1", StatusCode: http.StatusFound},
+		{URL: ts.URL + "/r/0", StatusCode: http.StatusOK},
+	}, RedirectChain(resp))
+
+	c := NewClient(context.Background(), WithMaxRedirects(2))
+	resp, err = c.Get(ts.URL + "/r/2")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	_, err = c.Get(ts.URL + "/r/3")
+	assert.ErrorIs(t, err, ErrTooManyRedirects)
+
+	resp, err = NewClient(context.Background(), WithNoRedirects()).Get(ts.URL + "/r/3")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, []Redirect{{URL: ts.URL + "/r/3", StatusCode: http.StatusFound}}, RedirectChain(resp))
+
+	c = NewClient(context.Background(), WithSameHostRedirects())
+	resp, err = c.Get(ts.URL + "/r/1")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	_, err = c.Get(ts.URL + "/?to=" + strings.Replace(ts.URL, "127.0.0.1", "localhost", 1))
+	assert.ErrorIs(t, err, ErrRedirectOffHost)
+
+	errStop := errors.New("stop")
+	c = NewClient(context.Background(), WithRedirectPolicy(func(req *http.Request, via []*http.Request) error {
+		if req.URL.Path == "/r/1" {
+			return errStop
+		}
+		return nil
+	}))
+	_, err = c.Get(ts.URL + "/r/3")
+	assert.ErrorIs(t, err, errStop)
+}
+
+func TestNoHTTPSDowngrade(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL, http.StatusFound)
+	}))
+	defer secure.Close()
+
+	resp, err := NewClient(context.Background(), WithInsecureSkipVerify()).Get(secure.URL)
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "plain", body)
+
+	_, err = NewClient(context.Background(), WithInsecureSkipVerify(), WithNoHTTPSDowngrade()).Get(secure.URL)
+	assert.ErrorIs(t, err, ErrRedirectDowngrade)
+}
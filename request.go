@@ -66,6 +66,24 @@ func PostForm(url string, data url.Values, opts ...RequestOption) (resp *http.Re
 	return NewClient(context.Background()).PostForm(url, data, opts...)
 }
 
+// PostFormStruct issues a POST to the specified URL, with the fields of v
+// URL-encoded as the request body, see Client.PostFormStruct.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func PostFormStruct(url string, v any, opts ...RequestOption) (resp *http.Response, err error) {
+	return NewClient(context.Background()).PostFormStruct(url, v, opts...)
+}
+
+// PostMultipartStruct issues a POST to the specified URL, with the fields of
+// v as multipart form, see Client.PostMultipartStruct.
+//
+// When err is nil, resp always contains a non-nil resp.Body.
+// Caller should close resp.Body when done reading from it.
+func PostMultipartStruct(url string, v any, opts ...RequestOption) (resp *http.Response, err error) {
+	return NewClient(context.Background()).PostMultipartStruct(url, v, opts...)
+}
+
 // UploadFile upload file struct
 type UploadFile struct {
 	FieldName string
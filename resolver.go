@@ -0,0 +1,375 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsCacheMaxEntries is the number of cached host names above which expired
+// entries are purged.
+const dnsCacheMaxEntries = 4096
+
+// lookupFunc resolves host to its addresses. A negative ttl means the
+// lookup does not know how long the result is valid.
+type lookupFunc func(ctx context.Context, host string) (addrs []netip.Addr, ttl time.Duration, err error)
+
+// netResolverLookup returns a lookupFunc using r.
+func netResolverLookup(r *net.Resolver) lookupFunc {
+	return func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		addrs, err := r.LookupNetIP(ctx, "ip", host)
+		return addrs, -1, err
+	}
+}
+
+// resolver resolves the host names dialed by a client.
+type resolver struct {
+	lookup    lookupFunc
+	overrides map[string][]netip.Addr
+	cache     *dnsCache
+	policy    *destinationPolicy
+	// dialTimeout is the timeout of a dial, shared by its addresses.
+	dialTimeout time.Duration
+}
+
+// newResolver returns the resolver of a client, or nil when the dialer can
+// resolve host names itself.
+func newResolver(options *ClientOptions) (*resolver, error) {
+	if options.resolver == nil && len(options.dnsServers) == 0 && options.doh == nil && options.dnsCache == nil && len(options.hostOverrides) == 0 &&
+		options.destinationPolicy == nil {
+		return nil, nil
+	}
+	system := netResolverLookup(net.DefaultResolver)
+	if options.resolver != nil {
+		system = netResolverLookup(options.resolver)
+	}
+	r := &resolver{lookup: system, dialTimeout: options.dialTimeout}
+	if len(options.dnsServers) > 0 {
+		client, err := newDNSClient(options.dnsServers)
+		if err != nil {
+			return nil, err
+		}
+		r.lookup = client.lookup
+	}
+	cache := options.dnsCache
+	if options.doh != nil {
+		client, err := newDoHClient(options.doh)
+		if err != nil {
+			return nil, err
+		}
+		r.lookup = client.lookup
+		if !options.doh.DisableFallback {
+			r.lookup = fallbackLookup(client.lookup, system)
+		}
+		if cache == nil {
+			cache = &dnsCacheConfig{ttl: time.Minute, negativeTTL: 30 * time.Second}
+		}
+	}
+	if cache != nil {
+		r.cache = &dnsCache{ttl: cache.ttl, negativeTTL: cache.negativeTTL}
+	}
+	if options.destinationPolicy != nil {
+		policy, err := newDestinationPolicy(options.destinationPolicy)
+		if err != nil {
+			return nil, err
+		}
+		r.policy = policy
+	}
+	for host, ip := range options.hostOverrides {
+		addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+		if err != nil {
+			return nil, fmt.Errorf("httputil: invalid host override %s: %w", host, err)
+		}
+		if r.overrides == nil {
+			r.overrides = make(map[string][]netip.Addr)
+		}
+		key := strings.ToLower(host)
+		r.overrides[key] = append(r.overrides[key], addr.Unmap())
+	}
+	return r, nil
+}
+
+// resolve returns the addresses of host, dialed on port.
+func (r *resolver) resolve(ctx context.Context, host, port string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if addrs, ok := r.overrides[net.JoinHostPort(host, port)]; ok {
+		return addrs, nil
+	}
+	if addrs, ok := r.overrides[host]; ok {
+		return addrs, nil
+	}
+	if r.cache != nil {
+		return r.cache.lookup(ctx, host, r.lookup)
+	}
+	addrs, _, err := r.lookup(ctx, host)
+	return addrs, err
+}
+
+// resolveDestination returns the addresses of host, dialed on port,
+// checking them against the destination policy.
+func (r *resolver) resolveDestination(ctx context.Context, host, port string) ([]netip.Addr, error) {
+	addrs, err := r.resolve(ctx, host, port)
+	if err != nil || r.policy == nil || isProxyDial(ctx) {
+		return addrs, err
+	}
+	for _, addr := range addrs {
+		if err := r.policy.check(host, addr, port); err != nil {
+			return nil, err
+		}
+	}
+	return addrs, nil
+}
+
+// dialContext returns a dial function resolving host names with r and
+// dialing the addresses with dial until one succeeds, see dialAddrs.
+func (r *resolver) dialContext(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := r.resolveDestination(ctx, host, port)
+		if err != nil {
+			return nil, err
+		}
+		return dialAddrs(ctx, dial, network, addrs, port, r.dialTimeout)
+	}
+}
+
+// fallbackDelay is the delay before the addresses of the other family are
+// dialed, as the default of net.Dialer.FallbackDelay.
+const fallbackDelay = 300 * time.Millisecond
+
+// dialAddrs dials the addresses of network like net.Dialer: the addresses of
+// the family of the first one are dialed in order, racing those of the other
+// family once fallbackDelay elapsed or the first family failed (Happy
+// Eyeballs, RFC 6555). Each attempt gets a share of the rest of timeout, or
+// of the context deadline if earlier.
+func dialAddrs(ctx context.Context, dial dialFunc, network string, addrs []netip.Addr, port string, timeout time.Duration) (net.Conn, error) {
+	var primaries, fallbacks []netip.Addr
+	for _, addr := range addrs {
+		if (strings.HasSuffix(network, "4") && !addr.Is4()) || (strings.HasSuffix(network, "6") && !addr.Is6()) {
+			continue
+		}
+		if len(primaries) == 0 || addr.Is4() == primaries[0].Is4() {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	if len(primaries) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: network}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, dial, network, primaries, port)
+	}
+
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+	race := func(ctx context.Context, primary bool, addrs []netip.Addr) {
+		conn, err := dialSerial(ctx, dial, network, addrs, port)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: primary}:
+		case <-returned:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+	primaryCtx, primaryCancel := context.WithCancel(ctx)
+	defer primaryCancel()
+	go race(primaryCtx, true, primaries)
+	fallbackTimer := time.NewTimer(fallbackDelay)
+	defer fallbackTimer.Stop()
+	fallbackCtx, fallbackCancel := context.WithCancel(ctx)
+	defer fallbackCancel()
+
+	var primaryErr error
+	pending := 2
+	for {
+		select {
+		case <-fallbackTimer.C:
+			go race(fallbackCtx, false, fallbacks)
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+			}
+			if pending--; pending == 0 {
+				return nil, primaryErr
+			}
+			if res.primary && fallbackTimer.Stop() {
+				// dial the fallbacks now
+				fallbackTimer.Reset(0)
+			}
+		}
+	}
+}
+
+// dialSerial dials the addresses of network in order until one succeeds.
+func dialSerial(ctx context.Context, dial dialFunc, network string, addrs []netip.Addr, port string) (net.Conn, error) {
+	var firstErr error
+	for i, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			break
+		}
+		conn, err := dialPartial(ctx, dial, network, net.JoinHostPort(addr.String(), port), len(addrs)-i)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// dialPartial dials addr with a deadline sharing the rest of the context
+// deadline between the remaining addresses, as net.Dialer does.
+func dialPartial(ctx context.Context, dial dialFunc, network, addr string, remaining int) (net.Conn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return dial(ctx, network, addr)
+	}
+	const minTimeout = 2 * time.Second
+	rest := time.Until(deadline)
+	timeout := rest / time.Duration(remaining)
+	if timeout < minTimeout {
+		timeout = min(rest, minTimeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return dial(ctx, network, addr)
+}
+
+// dnsCacheConfig configures the DNS cache of a client.
+type dnsCacheConfig struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+// dnsCache caches the addresses of host names, and host names that do not
+// exist. Concurrent lookups of a host name share a single query.
+type dnsCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*dnsCacheEntry
+	now     func() time.Time
+}
+
+// dnsCacheEntry is a cached lookup, complete once ready is closed.
+type dnsCacheEntry struct {
+	ready   chan struct{}
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// lookup returns the cached addresses of host, looking them up with lookup
+// when they are not cached or expired.
+func (c *dnsCache) lookup(ctx context.Context, host string, lookup lookupFunc) ([]netip.Addr, error) {
+	c.mu.Lock()
+	now := c.time()
+	entry, ok := c.entries[host]
+	if !ok || (isClosed(entry.ready) && !now.Before(entry.expires)) {
+		if c.entries == nil {
+			c.entries = make(map[string]*dnsCacheEntry)
+		}
+		if len(c.entries) >= dnsCacheMaxEntries {
+			for key, entry := range c.entries {
+				if isClosed(entry.ready) && !now.Before(entry.expires) {
+					delete(c.entries, key)
+				}
+			}
+		}
+		entry = &dnsCacheEntry{ready: make(chan struct{})}
+		c.entries[host] = entry
+		c.mu.Unlock()
+		c.fill(entry, host, lookup)
+	} else {
+		c.mu.Unlock()
+	}
+	select {
+	case <-entry.ready:
+		return entry.addrs, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fill looks up host in the background of the first caller, so that its
+// cancellation does not fail the callers sharing the query.
+func (c *dnsCache) fill(entry *dnsCacheEntry, host string, lookup lookupFunc) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		addrs, ttl, err := lookup(ctx, host)
+		var dnsErr *net.DNSError
+		switch {
+		case err == nil && len(addrs) > 0:
+			if ttl < 0 {
+				ttl = c.ttl
+			}
+		case err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound):
+			if err == nil {
+				err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			if ttl < 0 || ttl > c.negativeTTL {
+				ttl = c.negativeTTL
+			}
+		default:
+			ttl = 0
+		}
+		c.mu.Lock()
+		entry.addrs, entry.err, entry.expires = addrs, err, c.time().Add(ttl)
+		close(entry.ready)
+		if ttl <= 0 && c.entries[host] == entry {
+			delete(c.entries, host)
+		}
+		c.mu.Unlock()
+	}()
+}
+
+// time returns the current time.
+func (c *dnsCache) time() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// isClosed reports whether ch is closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
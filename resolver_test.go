@@ -0,0 +1,273 @@
+package httputil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSClient(t *testing.T) {
+	server, queries := newTestDNSServer(t)
+	client, err := newDNSClient([]string{server})
+	assert.Nil(t, err)
+
+	addrs, ttl, err := client.lookup(context.Background(), "a.test")
+	assert.Nil(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, addrs)
+	assert.Equal(t, 30*time.Second, ttl)
+
+	addrs, ttl, err = client.lookup(context.Background(), "big.test")
+	assert.Nil(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("::1")}, addrs)
+	assert.Equal(t, 10*time.Second, ttl)
+
+	_, ttl, err = client.lookup(context.Background(), "missing.test")
+	var dnsErr *net.DNSError
+	assert.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound)
+	assert.Equal(t, 120*time.Second, ttl)
+
+	addrs, _, err = client.lookup(context.Background(), "localhost")
+	assert.Nil(t, err)
+	assert.Len(t, addrs, 2)
+	assert.Equal(t, int32(8), queries.Load())
+
+	_, err = newDNSClient([]string{"dns.test"})
+	assert.NotNil(t, err)
+}
+
+func TestDNSCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := &dnsCache{ttl: time.Minute, negativeTTL: 10 * time.Second, now: func() time.Time { return now }}
+	var calls int
+	results := map[string]func() ([]netip.Addr, time.Duration, error){
+		"ttl.test": func() ([]netip.Addr, time.Duration, error) {
+			return []netip.Addr{netip.MustParseAddr("192.0.2.1")}, 5 * time.Second, nil
+		},
+		"system.test": func() ([]netip.Addr, time.Duration, error) {
+			return []netip.Addr{netip.MustParseAddr("192.0.2.2")}, -1, nil
+		},
+		"missing.test": func() ([]netip.Addr, time.Duration, error) {
+			return nil, time.Hour, &net.DNSError{Err: "no such host", IsNotFound: true}
+		},
+		"fail.test": func() ([]netip.Addr, time.Duration, error) {
+			return nil, -1, errors.New("timeout")
+		},
+	}
+	lookup := func(ctx context.Context, host string) ([]netip.Addr, time.Duration, error) {
+		calls++
+		return results[host]()
+	}
+	resolve := func(host string) error {
+		_, err := cache.lookup(context.Background(), host, lookup)
+		return err
+	}
+
+	for range 2 {
+		assert.Nil(t, resolve("ttl.test"))
+		assert.Nil(t, resolve("system.test"))
+		assert.NotNil(t, resolve("missing.test"))
+		assert.NotNil(t, resolve("fail.test"))
+	}
+	assert.Equal(t, 5, calls)
+
+	now = now.Add(5 * time.Second)
+	resolve("ttl.test")
+	resolve("system.test")
+	assert.Equal(t, 6, calls)
+
+	now = now.Add(5 * time.Second)
+	resolve("missing.test")
+	assert.Equal(t, 7, calls)
+
+	now = now.Add(time.Minute)
+	resolve("system.test")
+	assert.Equal(t, 8, calls)
+}
+
+func TestHostOverrides(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+	port := ts.Listener.Addr().(*net.TCPAddr).Port
+	target := "http://example.test:" + strconv.Itoa(port) + "/"
+
+	resp, err := NewClient(context.Background(), WithHostOverrides(map[string]string{"Example.test": "127.0.0.1"})).Get(target)
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "example.test:"+strconv.Itoa(port), body)
+
+	resp, err = NewClient(context.Background(), WithHostOverrides(map[string]string{"example.test:" + strconv.Itoa(port): "[::ffff:127.0.0.1]"})).Get(target)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	c := NewClient(context.Background(), WithHostOverrides(map[string]string{"example.test": "localhost"}))
+	assert.NotNil(t, c.Err())
+}
+
+func TestDNSServers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	server, queries := newTestDNSServer(t)
+	u, _ := url.Parse(ts.URL)
+	u.Host = "a.test:" + u.Port()
+
+	c := NewClient(context.Background(), WithDNSServers(server), WithDNSCache(time.Minute, time.Minute))
+	for range 3 {
+		resp, err := c.Get(u.String())
+		assert.Nil(t, err)
+		body, _ := ReadString(resp)
+		assert.Equal(t, "ok", body)
+		c.Close()
+	}
+	assert.Equal(t, int32(2), queries.Load())
+
+	u.Host = "missing.test:" + u.Port()
+	_, err := c.Get(u.String())
+	var dnsErr *net.DNSError
+	assert.ErrorAs(t, err, &dnsErr)
+}
+
+// newTestDNSServer starts a DNS server on UDP and TCP answering:
+//   - a.test: A 127.0.0.1 (TTL 30), no AAAA (SOA minimum 60)
+//   - big.test: truncated over UDP, AAAA ::1 (TTL 10) over TCP
+//   - missing.test: NXDOMAIN (SOA TTL 300, minimum 120)
+func newTestDNSServer(t *testing.T) (string, *atomic.Int32) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	queries := &atomic.Int32{}
+	answer := func(query []byte, tcp bool) []byte {
+		queries.Add(1)
+		return testDNSAnswer(query, tcp)
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(answer(buf[:n], false), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				data := answer(query, true)
+				binary.BigEndian.PutUint16(length[:], uint16(len(data)))
+				conn.Write(append(length[:], data...))
+			}()
+		}
+	}()
+	return pc.LocalAddr().String(), queries
+}
+
+// testDNSAnswer answers query as described in newTestDNSServer.
+func testDNSAnswer(query []byte, tcp bool) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil
+	}
+	q := msg.Questions[0]
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: msg.ID, Response: true, RecursionAvailable: true},
+		Questions: msg.Questions,
+	}
+	soa := func(ttl, minTTL uint32) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("test."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns.test."), MBox: dnsmessage.MustNewName("admin.test."), MinTTL: minTTL},
+		}
+	}
+	header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET}
+	switch {
+	case q.Name.String() == "a.test." && q.Type == dnsmessage.TypeA:
+		header.TTL = 30
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}})
+	case q.Name.String() == "a.test.":
+		resp.Authorities = append(resp.Authorities, soa(300, 60))
+	case q.Name.String() == "big.test." && !tcp:
+		resp.Truncated = true
+	case q.Name.String() == "big.test." && q.Type == dnsmessage.TypeAAAA:
+		header.TTL = 10
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: netip.IPv6Loopback().As16()}})
+	case q.Name.String() == "big.test.":
+		resp.Authorities = append(resp.Authorities, soa(300, 60))
+	default:
+		resp.RCode = dnsmessage.RCodeNameError
+		resp.Authorities = append(resp.Authorities, soa(300, 120))
+	}
+	data, _ := resp.Pack()
+	return data
+}
+
+func TestDialAddrs(t *testing.T) {
+	stalled := netip.MustParseAddr("2001:db8::1")
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == net.JoinHostPort(stalled.String(), "80") {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	// an unreachable IPv6 address does not delay IPv4 more than the fallback delay
+	start := time.Now()
+	conn, err := dialAddrs(context.Background(), dial, "tcp", []netip.Addr{stalled, netip.MustParseAddr("127.0.0.1")}, "80", time.Minute)
+	assert.Nil(t, err)
+	conn.Close()
+	assert.Less(t, time.Since(start), 5*fallbackDelay)
+
+	_, err = dialAddrs(context.Background(), dial, "tcp4", []netip.Addr{stalled}, "80", time.Minute)
+	assert.NotNil(t, err)
+
+	// the timeout is shared by the addresses
+	var timeouts []time.Duration
+	refuse := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		deadline, _ := ctx.Deadline()
+		timeouts = append(timeouts, time.Until(deadline).Round(time.Second))
+		return nil, errors.New("refused")
+	}
+	addrs := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2"), netip.MustParseAddr("192.0.2.3")}
+	_, err = dialAddrs(context.Background(), refuse, "tcp", addrs, "80", 30*time.Second)
+	assert.EqualError(t, err, "refused")
+	assert.Equal(t, []time.Duration{10 * time.Second, 15 * time.Second, 30 * time.Second}, timeouts)
+}
@@ -0,0 +1,440 @@
+package httputil
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm      = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat     = "20060102T150405Z"
+	sigV4DateFormat     = "20060102"
+	sigV4Unsigned       = "UNSIGNED-PAYLOAD"
+	sigV4Streaming      = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4DefaultChunk   = 64 << 10
+	sigV4ChunkSignature = ";chunk-signature="
+)
+
+// SigV4Signer signs requests with AWS Signature Version 4.
+//
+// Use [SigV4Signer.Middleware] with [WithMiddleware] to sign every request of
+// a Client, or [SigV4Signer.Presign] to create presigned URLs.
+type SigV4Signer struct {
+	// AccessKeyID, SecretAccessKey and SessionToken are the AWS credentials.
+	// SessionToken is only needed for temporary credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Region and Service scope the signature, e.g. "us-east-1" and "s3".
+	Region  string
+	Service string
+	// UnsignedPayload signs UNSIGNED-PAYLOAD instead of the hash of the body.
+	UnsignedPayload bool
+	// Streaming signs the body of PUT requests in aws-chunked encoding instead
+	// of hashing it upfront, so uploads are not buffered in memory. It
+	// requires a known Content-Length.
+	Streaming bool
+	// ChunkSize is the chunk size of streaming uploads. Defaults to 64 KiB.
+	ChunkSize int
+
+	now func() time.Time
+}
+
+// Middleware returns a Middleware signing every request with s.
+func (s *SigV4Signer) Middleware() Middleware {
+	return SignerMiddleware(s.Sign)
+}
+
+// Sign signs req by setting its Authorization and X-Amz-* headers.
+//
+// Unless the payload is unsigned or streamed, the body is hashed, reading it
+// through req.GetBody when available or buffering it otherwise.
+func (s *SigV4Signer) Sign(req *http.Request) error {
+	t := s.time()
+	amzDate := t.Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	streaming := s.Streaming && hasBody && req.Method == http.MethodPut && req.ContentLength > 0
+	var payloadHash string
+	switch {
+	case streaming:
+		payloadHash = sigV4Streaming
+		decodedLength := req.ContentLength
+		req.Header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(decodedLength, 10))
+		if encoding := req.Header.Get("Content-Encoding"); encoding != "" {
+			req.Header.Set("Content-Encoding", "aws-chunked,"+encoding)
+		} else {
+			req.Header.Set("Content-Encoding", "aws-chunked")
+		}
+		req.ContentLength = sigV4ChunkedLength(decodedLength, s.chunkSize())
+	case s.UnsignedPayload:
+		payloadHash = sigV4Unsigned
+	default:
+		var err error
+		if payloadHash, err = bodySHA256(req); err != nil {
+			return err
+		}
+	}
+	if streaming || s.UnsignedPayload || s.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := sigV4CanonicalHeaders(req, streaming)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req.URL),
+		sigV4CanonicalQuery(req.URL.RawQuery),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	signingKey := s.signingKey(t)
+	scope := s.scope(t)
+	signature := hex.EncodeToString(hmacSHA256(signingKey, sigV4StringToSign(amzDate, scope, canonicalRequest)))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.AccessKeyID, scope, signedHeaders, signature))
+
+	if streaming {
+		chunker := &sigV4Chunker{
+			key:       signingKey,
+			amzDate:   amzDate,
+			scope:     scope,
+			seed:      signature,
+			chunkSize: s.chunkSize(),
+		}
+		req.Body = chunker.reader(req.Body)
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return chunker.reader(body), nil
+			}
+		}
+	}
+	return nil
+}
+
+// Presign returns a presigned URL for req valid for expires. Only the host
+// header is signed and the payload is unsigned.
+func (s *SigV4Signer) Presign(req *http.Request, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", errors.New("httputil: sigv4: presign expiry must be between 1 second and 7 days")
+	}
+	t := s.time()
+	amzDate := t.Format(sigV4TimeFormat)
+	scope := s.scope(t)
+	u := *req.URL
+	params := url.Values{}
+	params.Set("X-Amz-Algorithm", sigV4Algorithm)
+	params.Set("X-Amz-Credential", s.AccessKeyID+"/"+scope)
+	params.Set("X-Amz-Date", amzDate)
+	params.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	params.Set("X-Amz-SignedHeaders", "host")
+	if s.SessionToken != "" {
+		params.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	// keep the raw query, replacing the parameters set by presigning
+	var parts []string
+	for _, part := range strings.Split(u.RawQuery, "&") {
+		key, _, _ := strings.Cut(part, "=")
+		if part != "" && !params.Has(sigV4Unescape(key)) {
+			parts = append(parts, part)
+		}
+	}
+	query := strings.Join(append(parts, params.Encode()), "&")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(&u),
+		sigV4CanonicalQuery(query),
+		"host:" + requestHost(req) + "\n",
+		"host",
+		sigV4Unsigned,
+	}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(t), sigV4StringToSign(amzDate, scope, canonicalRequest)))
+	u.RawQuery = sigV4CanonicalQuery(query) + "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// time returns the signing time.
+func (s *SigV4Signer) time() time.Time {
+	if s.now != nil {
+		return s.now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// chunkSize returns the chunk size of streaming uploads.
+func (s *SigV4Signer) chunkSize() int {
+	if s.ChunkSize > 0 {
+		return s.ChunkSize
+	}
+	return sigV4DefaultChunk
+}
+
+// scope returns the credential scope at t.
+func (s *SigV4Signer) scope(t time.Time) string {
+	return t.Format(sigV4DateFormat) + "/" + s.Region + "/" + s.Service + "/aws4_request"
+}
+
+// signingKey derives the signing key at t.
+func (s *SigV4Signer) signingKey(t time.Time) []byte {
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), t.Format(sigV4DateFormat))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// canonicalURI returns the canonical URI of u. S3 paths are encoded once and
+// kept as is, other services normalize the path and encode it twice.
+func (s *SigV4Signer) canonicalURI(u *url.URL) string {
+	p := u.Path
+	if p == "" {
+		return "/"
+	}
+	if s.Service == "s3" {
+		return sigV4Escape(p, false)
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return sigV4Escape(sigV4Escape(cleaned, false), false)
+}
+
+// sigV4StringToSign returns the string to sign of a canonical request.
+func sigV4StringToSign(amzDate, scope, canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	return sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+}
+
+// sigV4CanonicalQuery returns the canonical query string of the raw query.
+// Its keys and values are decoded as sent, keeping a "+" rather than
+// reading it as a space like url.Values, and re-encoded.
+func sigV4CanonicalQuery(rawQuery string) string {
+	type pair struct{ key, value string }
+	var pairs []pair
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		pairs = append(pairs, pair{sigV4Escape(sigV4Unescape(key), true), sigV4Escape(sigV4Unescape(value), true)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].value < pairs[j].value
+	})
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.key + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+// sigV4CanonicalHeaders returns the signed headers and canonical headers of
+// req. Host, Content-Type, Content-MD5 and X-Amz-* headers are signed, plus
+// Content-Encoding and Content-Length of streaming uploads.
+func sigV4CanonicalHeaders(req *http.Request, streaming bool) (signed, canonical string) {
+	headers := map[string]string{"host": requestHost(req)}
+	for key, values := range req.Header {
+		name := strings.ToLower(key)
+		switch {
+		case name == "content-type", name == "content-md5", strings.HasPrefix(name, "x-amz-"):
+		case streaming && name == "content-encoding":
+		default:
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+	if streaming {
+		headers["content-length"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+// bodySHA256 returns the hex SHA-256 of the body of req, keeping the
+// body readable.
+func bodySHA256(req *http.Request) (string, error) {
+	hasher := sha256.New()
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hasher, body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+	default:
+		payload, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		hasher.Write(payload)
+		req.Body = io.NopCloser(bytes.NewReader(payload))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(payload)), nil
+		}
+		req.ContentLength = int64(len(payload))
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// sigV4Escape URI-encodes s as specified by SigV4: every byte except the
+// unreserved characters is percent-encoded, and "/" too if encodeSlash.
+func sigV4Escape(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAlphaNum(c) || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
+
+// sigV4Unescape decodes the percent-encoded s, or returns s if it is not
+// validly encoded.
+func sigV4Unescape(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+// requestHost returns the host of req as sent in the Host header.
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sigV4ChunkedLength returns the aws-chunked encoded length of a body of
+// decodedLength bytes.
+func sigV4ChunkedLength(decodedLength int64, chunkSize int) int64 {
+	chunkLength := func(size int64) int64 {
+		return int64(len(strconv.FormatInt(size, 16))+len(sigV4ChunkSignature)+64+2) + size + 2
+	}
+	full := decodedLength / int64(chunkSize)
+	length := full*chunkLength(int64(chunkSize)) + chunkLength(0)
+	if rest := decodedLength % int64(chunkSize); rest > 0 {
+		length += chunkLength(rest)
+	}
+	return length
+}
+
+// sigV4Chunker encodes bodies in signed aws-chunked encoding.
+type sigV4Chunker struct {
+	key       []byte
+	amzDate   string
+	scope     string
+	seed      string
+	chunkSize int
+}
+
+// reader returns a reader of body in aws-chunked encoding.
+func (c *sigV4Chunker) reader(body io.ReadCloser) io.ReadCloser {
+	return &sigV4ChunkReader{chunker: c, body: body, prev: c.seed, chunk: make([]byte, c.chunkSize)}
+}
+
+// sign returns the signature of a chunk following the chunk signed by prev.
+func (c *sigV4Chunker) sign(prev string, chunk []byte) string {
+	emptyHash := sha256.Sum256(nil)
+	chunkHash := sha256.Sum256(chunk)
+	stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + c.amzDate + "\n" + c.scope + "\n" + prev + "\n" +
+		hex.EncodeToString(emptyHash[:]) + "\n" + hex.EncodeToString(chunkHash[:])
+	return hex.EncodeToString(hmacSHA256(c.key, stringToSign))
+}
+
+// sigV4ChunkReader streams a body in signed aws-chunked encoding.
+type sigV4ChunkReader struct {
+	chunker *sigV4Chunker
+	body    io.ReadCloser
+	prev    string
+	chunk   []byte
+	buf     bytes.Buffer
+	done    bool
+}
+
+// Read implements io.Reader.
+func (r *sigV4ChunkReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.body, r.chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if n > 0 {
+			r.writeChunk(r.chunk[:n])
+		}
+		if n < len(r.chunk) {
+			r.writeChunk(nil)
+			r.done = true
+		}
+	}
+	return r.buf.Read(p)
+}
+
+// writeChunk buffers the encoding of chunk.
+func (r *sigV4ChunkReader) writeChunk(chunk []byte) {
+	r.prev = r.chunker.sign(r.prev, chunk)
+	r.buf.WriteString(strconv.FormatInt(int64(len(chunk)), 16) + sigV4ChunkSignature + r.prev + "\r\n")
+	r.buf.Write(chunk)
+	r.buf.WriteString("\r\n")
+}
+
+// Close implements io.Closer.
+func (r *sigV4ChunkReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,141 @@
+package httputil
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// snapshotVersion is the version of the snapshot format.
+const snapshotVersion = 1
+
+// ErrSnapshotKey is returned when a snapshot cannot be decrypted with the key
+// of WithSnapshotKey, or is encrypted and no key is set.
+var ErrSnapshotKey = errors.New("httputil: invalid snapshot key")
+
+// clientSnapshot is the state of a client saved by Client.Snapshot.
+type clientSnapshot struct {
+	Version     int          `json:"version"`
+	UserAgent   string       `json:"user_agent,omitempty"`
+	Headers     http.Header  `json:"headers,omitempty"`
+	Cookies     []jarCookie  `json:"cookies,omitempty"`
+	OAuth2Token *OAuth2Token `json:"oauth2_token,omitempty"`
+}
+
+// Snapshot returns the state of the client, to be restored with
+// NewClientFromSnapshot: its User-Agent and default headers, its cookies,
+// and the token of its OAuth2 authenticator. ETags are not saved, since the
+// client has no response cache.
+//
+// Cookies are read from the default jar or a PersistentJar. A client with
+// another jar set by WithCookieJar cannot list its cookies, and returns an
+// error.
+//
+// The snapshot is encrypted with AES-GCM if a key is set with
+// WithSnapshotKey, since it contains credentials.
+func (c *Client) Snapshot() ([]byte, error) {
+	snapshot := clientSnapshot{Version: snapshotVersion, UserAgent: c.opts.userAgent, Headers: c.opts.defaultHeaders}
+	switch jar := c.client.Jar.(type) {
+	case nil:
+	case *PersistentJar:
+		snapshot.Cookies = jar.snapshot()
+	case *defaultJar:
+		snapshot.Cookies = jar.recorded.snapshot()
+	default:
+		return nil, fmt.Errorf("httputil: snapshot: cannot list the cookies of %T", jar)
+	}
+	if o, ok := c.opts.authenticator.(*OAuth2); ok {
+		snapshot.OAuth2Token = o.cachedToken()
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.snapshotKey == nil {
+		return data, nil
+	}
+	aead, err := newSnapshotAEAD(c.opts.snapshotKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// NewClientFromSnapshot returns a client configured with opts and the state
+// saved by Client.Snapshot. opts take precedence over the saved User-Agent
+// and default headers, and must include the WithSnapshotKey of an
+// encrypted snapshot. Cookies are added to the cookie jar of the client,
+// and the OAuth2 token is set on the OAuth2 authenticator of opts, if any.
+func NewClientFromSnapshot(ctx context.Context, data []byte, opts ...ClientOption) (*Client, error) {
+	options := &ClientOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.snapshotKey != nil {
+		aead, err := newSnapshotAEAD(options.snapshotKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize() {
+			return nil, ErrSnapshotKey
+		}
+		data, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, ErrSnapshotKey
+		}
+	} else if len(data) > 0 && data[0] != '{' {
+		return nil, ErrSnapshotKey
+	}
+	var snapshot clientSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("httputil: invalid snapshot: %w", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("httputil: unsupported snapshot version %d", snapshot.Version)
+	}
+	c := NewClient(ctx, append([]ClientOption{WithUserAgent(snapshot.UserAgent), WithDefaultHeaders(snapshot.Headers)}, opts...)...)
+	switch jar := c.client.Jar.(type) {
+	case nil:
+	case *PersistentJar:
+		cookies := make([]*jarCookie, len(snapshot.Cookies))
+		for i := range snapshot.Cookies {
+			cookies[i] = &snapshot.Cookies[i]
+		}
+		jar.add(cookies)
+	default:
+		for _, cookie := range snapshot.Cookies {
+			u := &url.URL{Scheme: "http", Host: cookie.Domain, Path: cookie.Path}
+			if strings.Contains(u.Host, ":") {
+				u.Host = "[" + u.Host + "]"
+			}
+			if cookie.Secure {
+				u.Scheme = "https"
+			}
+			jar.SetCookies(u, []*http.Cookie{cookie.httpCookie()})
+		}
+	}
+	if o, ok := c.opts.authenticator.(*OAuth2); ok && snapshot.OAuth2Token != nil {
+		o.SetToken(snapshot.OAuth2Token)
+	}
+	return c, nil
+}
+
+// newSnapshotAEAD returns the AES-GCM cipher of key.
+func newSnapshotAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("httputil: snapshot key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,86 @@
+package httputil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	var issued atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token%d","token_type":"bearer","expires_in":3600}`, issued.Add(1))
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", HttpOnly: true})
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		cookie, _ := r.Cookie("session")
+		fmt.Fprintf(w, "%s %s %s %v", r.UserAgent(), r.Header.Get("X-Job"), r.Header.Get("Authorization"), cookie)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	oauth2 := func() ClientOption {
+		return WithOAuth2(OAuth2Config{TokenURL: ts.URL + "/token", ClientID: "id", ClientSecret: "secret"})
+	}
+	key := bytes.Repeat([]byte{1}, 32)
+
+	c := NewClient(context.Background(), WithUserAgent("crawler/1"), WithDefaultHeaders(http.Header{"X-Job": {"1"}}), oauth2(), WithSnapshotKey(key))
+	resp, err := c.Get(ts.URL + "/login")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	data, err := c.Snapshot()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "token1")
+
+	restored, err := NewClientFromSnapshot(context.Background(), data, oauth2(), WithSnapshotKey(key))
+	assert.Nil(t, err)
+	resp, err = restored.Get(ts.URL + "/api")
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "crawler/1 1 Bearer token1 session=s1", body)
+	assert.Equal(t, int32(1), issued.Load())
+
+	// the restored default jar is saved again
+	again, err := restored.Snapshot()
+	assert.Nil(t, err)
+	restored, err = NewClientFromSnapshot(context.Background(), again, WithSnapshotKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, []*http.Cookie{{Name: "session", Value: "s1"}}, restored.Cookies(ts.URL))
+
+	// options take precedence, and other jars are filled too
+	jar, _ := NewPersistentJar("")
+	restored, err = NewClientFromSnapshot(context.Background(), data, WithSnapshotKey(key), WithUserAgent("crawler/2"), WithCookieJar(jar))
+	assert.Nil(t, err)
+	resp, err = restored.Get(ts.URL + "/api")
+	assert.Nil(t, err)
+	body, _ = ReadString(resp)
+	assert.Equal(t, "crawler/2 1  session=s1", body)
+	assert.True(t, jar.All()[0].HttpOnly)
+
+	_, err = NewClientFromSnapshot(context.Background(), data)
+	assert.ErrorIs(t, err, ErrSnapshotKey)
+	_, err = NewClientFromSnapshot(context.Background(), data, WithSnapshotKey(bytes.Repeat([]byte{2}, 32)))
+	assert.ErrorIs(t, err, ErrSnapshotKey)
+
+	// jars that cannot list their cookies are not saved silently
+	other, _ := cookiejar.New(nil)
+	_, err = NewClient(context.Background(), WithCookieJar(other)).Snapshot()
+	assert.NotNil(t, err)
+
+	// unencrypted
+	data, err = NewClient(context.Background(), WithUserAgent("crawler/3")).Snapshot()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"version":1,"user_agent":"crawler/3"}`, string(data))
+	_, err = NewClientFromSnapshot(context.Background(), []byte(`{"version":2}`))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,202 @@
+package httputil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// newTLSConfig builds the TLS configuration of a client, or returns nil when
+// no TLS option is set.
+//
+// Client certificates and root CAs loaded from files are reloaded when the
+// files change on disk, so rotated certificates are picked up by new
+// connections without recreating the client. The client certificate is
+// reloaded by the returned config, the root CAs through the returned
+// reloader, see rootCAsTransport.
+func newTLSConfig(options *ClientOptions) (config *tls.Config, roots *fileReloader[*x509.CertPool], err error) {
+	if options.tlsConfig == nil && len(options.rootCAFiles) == 0 && options.clientCertFile == "" &&
+		options.minTLSVersion == 0 && options.serverName == "" && !options.insecureSkipVerify && len(options.pins) == 0 {
+		return nil, nil, nil
+	}
+	config = &tls.Config{}
+	if options.tlsConfig != nil {
+		config = options.tlsConfig.Clone()
+	}
+	if options.minTLSVersion != 0 {
+		config.MinVersion = options.minTLSVersion
+	}
+	if options.serverName != "" {
+		config.ServerName = options.serverName
+	}
+	if options.insecureSkipVerify {
+		config.InsecureSkipVerify = true
+	}
+	if options.clientCertFile != "" {
+		certFile, keyFile := options.clientCertFile, options.clientKeyFile
+		cert := &fileReloader[*tls.Certificate]{
+			files: []string{certFile, keyFile},
+			load: func() (*tls.Certificate, error) {
+				cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+				return &cert, err
+			},
+		}
+		if _, err := cert.get(); err != nil {
+			return nil, nil, fmt.Errorf("httputil: load client certificate: %w", err)
+		}
+		config.Certificates = nil
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert.get()
+		}
+	}
+	if len(options.pins) > 0 {
+		pins, report := options.pins, options.pinReport
+		verifyConnection := config.VerifyConnection
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if err := pins.verifyConnection(cs); err != nil {
+				if report == nil {
+					return err
+				}
+				report(err)
+			}
+			if verifyConnection != nil {
+				return verifyConnection(cs)
+			}
+			return nil
+		}
+	}
+	if len(options.rootCAFiles) > 0 {
+		files := options.rootCAFiles
+		roots = &fileReloader[*x509.CertPool]{
+			files: files,
+			load: func() (*x509.CertPool, error) {
+				return loadCertPool(files)
+			},
+		}
+		if config.RootCAs, err = roots.get(); err != nil {
+			return nil, nil, fmt.Errorf("httputil: load root CAs: %w", err)
+		}
+	}
+	return config, roots, nil
+}
+
+// rootCAsTransport replaces its transport with a copy using the new pool of
+// root CAs when the root CA files change. Connections of the previous
+// transport are closed once idle.
+type rootCAsTransport struct {
+	roots *fileReloader[*x509.CertPool]
+
+	mu        sync.Mutex
+	pool      *x509.CertPool
+	transport *http.Transport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *rootCAsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current().RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the current transport.
+func (t *rootCAsTransport) CloseIdleConnections() {
+	t.current().CloseIdleConnections()
+}
+
+// current returns the transport using the current pool of root CAs.
+func (t *rootCAsTransport) current() *http.Transport {
+	pool, _ := t.roots.get()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if pool != t.pool {
+		transport := t.transport.Clone()
+		transport.TLSClientConfig.RootCAs = pool
+		t.transport.CloseIdleConnections()
+		t.transport, t.pool = transport, pool
+	}
+	return t.transport
+}
+
+// loadCertPool loads the PEM certificates of files into a new pool.
+func loadCertPool(files []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", file)
+		}
+	}
+	return pool, nil
+}
+
+// fileCheckInterval is the minimum interval between two checks of the files
+// of a fileReloader.
+const fileCheckInterval = time.Second
+
+// fileReloader caches a value loaded from files and loads it again when the
+// modification time or size of one of the files changes, checked at most
+// once per fileCheckInterval. When reloading fails, e.g. because a file is
+// being rewritten, the previous value is kept.
+type fileReloader[T any] struct {
+	files []string
+	load  func() (T, error)
+
+	mu      sync.Mutex
+	value   T
+	loaded  bool
+	stats   []fileStat
+	checked time.Time
+	now     func() time.Time
+}
+
+// fileStat identifies a version of a file.
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// get returns the current value.
+func (r *fileReloader[T]) get() (T, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
+	if r.loaded && now.Sub(r.checked) < fileCheckInterval {
+		return r.value, nil
+	}
+	r.checked = now
+	stats := make([]fileStat, len(r.files))
+	changed := !r.loaded
+	for i, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			if r.loaded {
+				return r.value, nil
+			}
+			return r.value, err
+		}
+		stats[i] = fileStat{modTime: info.ModTime(), size: info.Size()}
+		if r.loaded && stats[i] != r.stats[i] {
+			changed = true
+		}
+	}
+	if !changed {
+		return r.value, nil
+	}
+	value, err := r.load()
+	if err != nil {
+		if r.loaded {
+			return r.value, nil
+		}
+		return value, err
+	}
+	r.value, r.loaded, r.stats = value, true, stats
+	return value, nil
+}
@@ -0,0 +1,166 @@
+package httputil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a certificate with its key, in PEM and parsed form.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate from template, self-signed if parent is nil.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.Nil(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// newTestPKI creates a CA with a server certificate for 127.0.0.1 and a client certificate.
+func newTestPKI(t *testing.T) (ca, server, client *testCert) {
+	ca = newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server = newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		DNSNames:    []string{"example.test"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client = newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	return
+}
+
+// newTestTLSServer starts a TLS server presenting cert and echoing the client certificate name.
+func newTestTLSServer(t *testing.T, ca, cert *testCert) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	pair, err := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+	assert.Nil(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+	}
+	ts.StartTLS()
+	return ts
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	file := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(file, data, 0o600))
+	return file
+}
+
+func TestClientTLS(t *testing.T) {
+	ca, server, client := newTestPKI(t)
+	ts := newTestTLSServer(t, ca, server)
+	defer ts.Close()
+
+	// unknown authority
+	_, err := NewClient(context.Background(), WithMinTLSVersion(tls.VersionTLS12)).Get(ts.URL)
+	assert.NotNil(t, err)
+
+	// custom root CA and client certificate
+	c := NewClient(context.Background(),
+		WithRootCAs(writeTestFile(t, "ca.pem", ca.certPEM)),
+		WithClientCertificate(writeTestFile(t, "client.pem", client.certPEM), writeTestFile(t, "client.key", client.keyPEM)),
+	)
+	assert.Nil(t, c.Err())
+	resp, err := c.Get(ts.URL)
+	assert.Nil(t, err)
+	body, err := ReadString(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "client", body)
+
+	// server name mismatch
+	_, err = NewClient(context.Background(), WithRootCAs(writeTestFile(t, "ca.pem", ca.certPEM)), WithServerName("other.test")).Get(ts.URL)
+	assert.NotNil(t, err)
+
+	// skip verification
+	resp, err = NewClient(context.Background(), WithInsecureSkipVerify()).Get(ts.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	// missing files
+	c = NewClient(context.Background(), WithRootCAs(filepath.Join(t.TempDir(), "missing.pem")))
+	assert.NotNil(t, c.Err())
+	_, err = c.Get(ts.URL)
+	assert.Equal(t, c.Err(), err)
+}
+
+func TestFileReloader(t *testing.T) {
+	file := writeTestFile(t, "value", []byte("first"))
+	now := time.Unix(1700000000, 0)
+	r := &fileReloader[string]{
+		files: []string{file},
+		load: func() (string, error) {
+			data, err := os.ReadFile(file)
+			return string(data), err
+		},
+		now: func() time.Time { return now },
+	}
+	value, err := r.get()
+	assert.Nil(t, err)
+	assert.Equal(t, "first", value)
+	assert.Nil(t, os.WriteFile(file, []byte("second!"), 0o600))
+	// the files are checked at most once per interval
+	value, err = r.get()
+	assert.Nil(t, err)
+	assert.Equal(t, "first", value)
+	now = now.Add(fileCheckInterval)
+	value, err = r.get()
+	assert.Nil(t, err)
+	assert.Equal(t, "second!", value)
+}
@@ -0,0 +1,119 @@
+package httputil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// dialFunc is a function dialing network connections, usable as a
+// golang.org/x/net/proxy dialer.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Dial dials addr.
+func (f dialFunc) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+// DialContext dials addr with ctx.
+func (f dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// newTransport builds the http.RoundTripper of a client. The shared
+// http.DefaultTransport is used when no option requires a dedicated one.
+func newTransport(options *ClientOptions) (http.RoundTripper, error) {
+	tlsConfig, roots, err := newTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := newResolver(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.proxy == "" && options.proxySelector == nil && options.proxyPAC == "" && tlsConfig == nil && resolver == nil &&
+		options.dialContext == nil && options.unixSocket == "" {
+		return http.DefaultTransport, nil
+	}
+	transport := cloneDefaultTransport()
+	dialer := &net.Dialer{
+		Timeout:   options.dialTimeout,
+		KeepAlive: options.keepAliveTimeout,
+	}
+	dial := dialFunc(dialer.DialContext)
+	if options.dialContext != nil {
+		dial = options.dialContext
+	}
+	if options.unixSocket != "" {
+		path := options.unixSocket
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+	} else if resolver != nil {
+		dial = resolver.dialContext(dial)
+	}
+	transport.DialContext = dial
+	transport.TLSHandshakeTimeout = options.tlsHandshakeTimeout
+	transport.OnProxyConnectResponse = proxyConnectResponse
+	if options.proxy != "" {
+		proxyURL, err := parseProxyURL(options.proxy)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL.Scheme == "socks5" {
+			// the transport resolves targets remotely with socks5, so
+			// dial through the proxy after resolving them here
+			transport.Proxy = nil
+			if dial, err = socks5DialContext(proxyURL, dial, resolver, options.dialTimeout); err != nil {
+				return nil, err
+			}
+			transport.DialContext = dial
+		} else {
+			transport.Proxy = proxyFunc(http.ProxyURL(proxyURL))
+		}
+	} else if options.proxySelector != nil {
+		transport.Proxy = proxyFunc(options.proxySelector)
+	} else if options.proxyPAC != "" {
+		transport.Proxy = proxyFunc(pacSelector)
+	} else if transport.Proxy != nil {
+		// the proxies of the environment are trusted like the others
+		transport.Proxy = proxyFunc(transport.Proxy)
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	var rt http.RoundTripper = transport
+	if roots != nil {
+		rt = &rootCAsTransport{roots: roots, pool: tlsConfig.RootCAs, transport: transport}
+	}
+	rt = &proxyTransport{next: rt, report: options.proxyReport}
+	if options.proxyPAC != "" {
+		rt = &pacTransport{pac: &pacScript{source: options.proxyPAC, resolver: resolver}, next: rt}
+	}
+	if resolver != nil && resolver.policy != nil {
+		rt = &destinationTransport{policy: resolver.policy, next: rt}
+	}
+	return rt, nil
+}
+
+// cloneDefaultTransport returns a clone of http.DefaultTransport, or a new
+// transport with its default settings if it was replaced by another
+// http.RoundTripper, e.g. by a test mock or an instrumentation wrapper.
+func cloneDefaultTransport() *http.Transport {
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		return transport.Clone()
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
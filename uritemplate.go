@@ -0,0 +1,283 @@
+package httputil
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// uriTemplateOperator is an expression operator of RFC 6570 (appendix A).
+type uriTemplateOperator struct {
+	first    string
+	sep      string
+	named    bool
+	ifEmpty  string
+	reserved bool
+}
+
+// uriTemplateOperators are the operators of RFC 6570 level 4.
+var uriTemplateOperators = map[byte]uriTemplateOperator{
+	'+': {sep: ",", reserved: true},
+	'#': {first: "#", sep: ",", reserved: true},
+	'.': {first: ".", sep: "."},
+	'/': {first: "/", sep: "/"},
+	';': {first: ";", sep: ";", named: true},
+	'?': {first: "?", sep: "&", named: true, ifEmpty: "="},
+	'&': {first: "&", sep: "&", named: true, ifEmpty: "="},
+}
+
+// Expand expands the URI template (RFC 6570, up to level 4) with vars, e.g.
+// "/repos/{owner}/{repo}/issues{?state,labels*}".
+//
+// Values are strings, numbers, booleans, time.Time or encoding.TextMarshaler
+// values, slices (lists) and maps (associative arrays, expanded in key
+// order). Variables that are missing, nil, or empty lists and maps are
+// undefined.
+func Expand(template string, vars map[string]any) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			if strings.IndexByte(template, '}') >= 0 {
+				return "", fmt.Errorf("httputil: URI template: unexpected '}'")
+			}
+			b.WriteString(escapeURITemplate(template, true))
+			return b.String(), nil
+		}
+		literal := template[:start]
+		if strings.IndexByte(literal, '}') >= 0 {
+			return "", fmt.Errorf("httputil: URI template: unexpected '}'")
+		}
+		b.WriteString(escapeURITemplate(literal, true))
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("httputil: URI template: unclosed expression %q", template[start:])
+		}
+		if err := expandExpression(&b, template[start+1:start+end], vars); err != nil {
+			return "", fmt.Errorf("httputil: URI template: expression {%s}: %w", template[start+1:start+end], err)
+		}
+		template = template[start+end+1:]
+	}
+}
+
+// expandExpression writes the expansion of the expression expr, without
+// its braces.
+func expandExpression(b *strings.Builder, expr string, vars map[string]any) error {
+	var op uriTemplateOperator
+	if expr != "" {
+		if o, ok := uriTemplateOperators[expr[0]]; ok {
+			op = o
+			expr = expr[1:]
+		} else if strings.IndexByte("=,!@|", expr[0]) >= 0 {
+			return fmt.Errorf("reserved operator %q", expr[0])
+		}
+	}
+	if expr == "" {
+		return fmt.Errorf("no variables")
+	}
+	if op.sep == "" {
+		op.sep = ","
+	}
+	first := true
+	for _, spec := range strings.Split(expr, ",") {
+		name, explode := strings.CutSuffix(spec, "*")
+		prefix := -1
+		if n, length, ok := strings.Cut(name, ":"); ok {
+			if explode {
+				return fmt.Errorf("invalid variable %q", spec)
+			}
+			l, err := strconv.Atoi(length)
+			if err != nil || l <= 0 || l > 9999 || length[0] == '0' {
+				return fmt.Errorf("invalid prefix length %q", length)
+			}
+			name, prefix = n, l
+		}
+		if !validVarName(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+		value, err := uriTemplateValue(vars[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if value == nil {
+			continue
+		}
+		if first {
+			b.WriteString(op.first)
+			first = false
+		} else {
+			b.WriteString(op.sep)
+		}
+		if err := expandValue(b, op, name, value, prefix, explode); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// expandValue writes the expansion of the defined value of the variable
+// name: a string, a list ([]string) or pairs of an associative array
+// ([][2]string).
+func expandValue(b *strings.Builder, op uriTemplateOperator, name string, value any, prefix int, explode bool) error {
+	escape := func(s string) string {
+		return escapeURITemplate(s, op.reserved)
+	}
+	named := func(name, value string) string {
+		if value == "" {
+			return name + op.ifEmpty
+		}
+		return name + "=" + value
+	}
+	var items []string
+	switch value := value.(type) {
+	case string:
+		if prefix >= 0 && utf8.RuneCountInString(value) > prefix {
+			value = string([]rune(value)[:prefix])
+		}
+		if op.named {
+			b.WriteString(named(name, escape(value)))
+		} else {
+			b.WriteString(escape(value))
+		}
+		return nil
+	case []string:
+		for _, item := range value {
+			if explode && op.named {
+				items = append(items, named(name, escape(item)))
+			} else {
+				items = append(items, escape(item))
+			}
+		}
+	case [][2]string:
+		for _, pair := range value {
+			switch {
+			case !explode:
+				items = append(items, escape(pair[0]), escape(pair[1]))
+			case op.named:
+				items = append(items, named(escape(pair[0]), escape(pair[1])))
+			default:
+				items = append(items, escape(pair[0])+"="+escape(pair[1]))
+			}
+		}
+	}
+	if prefix >= 0 {
+		return fmt.Errorf("prefix of a composite value")
+	}
+	switch {
+	case explode:
+		b.WriteString(strings.Join(items, op.sep))
+	case op.named:
+		b.WriteString(named(name, strings.Join(items, ",")))
+	default:
+		b.WriteString(strings.Join(items, ","))
+	}
+	return nil
+}
+
+// uriTemplateValue converts v to a string, a list ([]string) or the pairs of
+// an associative array ([][2]string) sorted by key, or nil if v is
+// undefined.
+func uriTemplateValue(v any) (any, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if implements(rv.Type(), textMarshalerType) {
+			break
+		}
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return string(rv.Bytes()), nil
+		}
+		if rv.Len() == 0 {
+			return nil, nil
+		}
+		list := make([]string, rv.Len())
+		for i := range list {
+			s, err := formatValue(rv.Index(i), fieldOptions{})
+			if err != nil {
+				return nil, err
+			}
+			list[i] = s
+		}
+		return list, nil
+	case reflect.Map:
+		if rv.Len() == 0 {
+			return nil, nil
+		}
+		var pairs [][2]string
+		for iter := rv.MapRange(); iter.Next(); {
+			k, err := formatValue(iter.Key(), fieldOptions{})
+			if err != nil {
+				return nil, err
+			}
+			v, err := formatValue(iter.Value(), fieldOptions{})
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, [2]string{k, v})
+		}
+		slices.SortFunc(pairs, func(a, b [2]string) int {
+			return strings.Compare(a[0], b[0])
+		})
+		return pairs, nil
+	}
+	return formatValue(rv, fieldOptions{})
+}
+
+// validVarName reports whether name is a varname of RFC 6570 section 2.3.
+func validVarName(name string) bool {
+	if name == "" || name[0] == '.' || name[len(name)-1] == '.' || strings.Contains(name, "..") {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_', c == '.':
+		case c == '%' && i+2 < len(name) && isHex(name[i+1]) && isHex(name[i+2]):
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// escapeURITemplate percent-encodes s, except unreserved characters, and
+// reserved characters and percent-encoded triplets if reserved is set.
+func escapeURITemplate(s string, reserved bool) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-._~", c) >= 0:
+			b.WriteByte(c)
+		case reserved && strings.IndexByte(":/?#[]@!$&'()*+,;=", c) >= 0:
+			b.WriteByte(c)
+		case reserved && c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteString(s[i : i+3])
+			i += 2
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		}
+	}
+	return b.String()
+}
+
+// isHex reports whether c is a hexadecimal digit.
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,181 @@
+package httputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpand(t *testing.T) {
+	// the examples of RFC 6570 section 3.2
+	vars := map[string]any{
+		"count":      []string{"one", "two", "three"},
+		"dom":        []string{"example", "com"},
+		"dub":        "me/too",
+		"hello":      "Hello World!",
+		"half":       "50%",
+		"var":        "value",
+		"who":        "fred",
+		"base":       "http://example.com/home/",
+		"path":       "/foo/bar",
+		"list":       []string{"red", "green", "blue"},
+		"keys":       map[string]string{"semi": ";", "dot": ".", "comma": ","},
+		"v":          6,
+		"x":          1024,
+		"y":          768,
+		"empty":      "",
+		"empty_keys": map[string]string{},
+		"undef":      nil,
+	}
+	for template, want := range map[string]string{
+		"{var}":                 "value",
+		"{hello}":               "Hello%20World%21",
+		"{half}":                "50%25",
+		"O{empty}X":             "OX",
+		"O{undef}X":             "OX",
+		"{x,y}":                 "1024,768",
+		"{x,hello,y}":           "1024,Hello%20World%21,768",
+		"?{x,empty}":            "?1024,",
+		"?{x,undef}":            "?1024",
+		"?{undef,y}":            "?768",
+		"{var:3}":               "val",
+		"{var:30}":              "value",
+		"{list}":                "red,green,blue",
+		"{list*}":               "red,green,blue",
+		"{keys}":                "comma,%2C,dot,.,semi,%3B",
+		"{keys*}":               "comma=%2C,dot=.,semi=%3B",
+		"{+var}":                "value",
+		"{+hello}":              "Hello%20World!",
+		"{+half}":               "50%25",
+		"{base}index":           "http%3A%2F%2Fexample.com%2Fhome%2Findex",
+		"{+base}index":          "http://example.com/home/index",
+		"O{+empty}X":            "OX",
+		"{+path}/here":          "/foo/bar/here",
+		"here?ref={+path}":      "here?ref=/foo/bar",
+		"up{+path}{var}/here":   "up/foo/barvalue/here",
+		"{+x,hello,y}":          "1024,Hello%20World!,768",
+		"{+path,x}/here":        "/foo/bar,1024/here",
+		"{+path:6}/here":        "/foo/b/here",
+		"{+list*}":              "red,green,blue",
+		"{+keys*}":              "comma=,,dot=.,semi=;",
+		"{#var}":                "#value",
+		"{#hello}":              "#Hello%20World!",
+		"{#half}":               "#50%25",
+		"foo{#empty}":           "foo#",
+		"foo{#undef}":           "foo",
+		"{#x,hello,y}":          "#1024,Hello%20World!,768",
+		"{#path,x}/here":        "#/foo/bar,1024/here",
+		"{#path:6}/here":        "#/foo/b/here",
+		"{#list}":               "#red,green,blue",
+		"{#keys*}":              "#comma=,,dot=.,semi=;",
+		"{.who}":                ".fred",
+		"{.who,who}":            ".fred.fred",
+		"{.half,who}":           ".50%25.fred",
+		"www{.dom*}":            "www.example.com",
+		"X{.var}":               "X.value",
+		"X{.empty}":             "X.",
+		"X{.undef}":             "X",
+		"X{.var:3}":             "X.val",
+		"X{.list}":              "X.red,green,blue",
+		"X{.list*}":             "X.red.green.blue",
+		"X{.keys}":              "X.comma,%2C,dot,.,semi,%3B",
+		"X{.keys*}":             "X.comma=%2C.dot=..semi=%3B",
+		"X{.empty_keys}":        "X",
+		"{/who}":                "/fred",
+		"{/who,who}":            "/fred/fred",
+		"{/half,who}":           "/50%25/fred",
+		"{/who,dub}":            "/fred/me%2Ftoo",
+		"{/var}":                "/value",
+		"{/var,empty}":          "/value/",
+		"{/var,undef}":          "/value",
+		"{/var,x}/here":         "/value/1024/here",
+		"{/var:1,var}":          "/v/value",
+		"{/list}":               "/red,green,blue",
+		"{/list*}":              "/red/green/blue",
+		"{/list*,path:4}":       "/red/green/blue/%2Ffoo",
+		"{/keys*}":              "/comma=%2C/dot=./semi=%3B",
+		"{;who}":                ";who=fred",
+		"{;half}":               ";half=50%25",
+		"{;empty}":              ";empty",
+		"{;v,empty,who}":        ";v=6;empty;who=fred",
+		"{;v,bar,who}":          ";v=6;who=fred",
+		"{;x,y}":                ";x=1024;y=768",
+		"{;x,y,empty}":          ";x=1024;y=768;empty",
+		"{;x,y,undef}":          ";x=1024;y=768",
+		"{;hello:5}":            ";hello=Hello",
+		"{;list}":               ";list=red,green,blue",
+		"{;list*}":              ";list=red;list=green;list=blue",
+		"{;keys}":               ";keys=comma,%2C,dot,.,semi,%3B",
+		"{;keys*}":              ";comma=%2C;dot=.;semi=%3B",
+		"{?who}":                "?who=fred",
+		"{?half}":               "?half=50%25",
+		"{?x,y}":                "?x=1024&y=768",
+		"{?x,y,empty}":          "?x=1024&y=768&empty=",
+		"{?x,y,undef}":          "?x=1024&y=768",
+		"{?var:3}":              "?var=val",
+		"{?list}":               "?list=red,green,blue",
+		"{?list*}":              "?list=red&list=green&list=blue",
+		"{?keys}":               "?keys=comma,%2C,dot,.,semi,%3B",
+		"{?keys*}":              "?comma=%2C&dot=.&semi=%3B",
+		"?fixed=yes{&x}":        "?fixed=yes&x=1024",
+		"{&x,y,empty}":          "&x=1024&y=768&empty=",
+		"{&var:3}":              "&var=val",
+		"{&list}":               "&list=red,green,blue",
+		"{&list*}":              "&list=red&list=green&list=blue",
+		"{&keys}":               "&keys=comma,%2C,dot,.,semi,%3B",
+		"{&keys*}":              "&comma=%2C&dot=.&semi=%3B",
+		"/repos/{owner}/{repo}": "/repos/%C3%A9/r",
+		"{count}":               "one,two,three",
+		"{count*}":              "one,two,three",
+		"{/count}":              "/one,two,three",
+		"{/count*}":             "/one/two/three",
+		"{;count}":              ";count=one,two,three",
+		"{;count*}":             ";count=one;count=two;count=three",
+		"{?count}":              "?count=one,two,three",
+		"{?count*}":             "?count=one&count=two&count=three",
+		"{&count*}":             "&count=one&count=two&count=three",
+		"a b{?undef}":           "a%20b",
+	} {
+		vars := vars
+		if template == "/repos/{owner}/{repo}" {
+			vars = map[string]any{"owner": "é", "repo": "r"}
+		}
+		got, err := Expand(template, vars)
+		assert.Nil(t, err, template)
+		assert.Equal(t, want, got, template)
+	}
+
+	for _, template := range []string{"{var", "var}", "{}", "{=var}", "{var:0}", "{var:10000}", "{list:3}", "{a..b}", "{var*:3}", "{va r}"} {
+		_, err := Expand(template, vars)
+		assert.NotNil(t, err, template)
+	}
+}
+
+func TestWithURITemplate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer ts.Close()
+
+	c := NewClient(context.Background(), WithBaseURL(ts.URL))
+	resp, err := c.Get("/repos/{owner}/{repo}/issues{?state,labels*}", WithURITemplate(map[string]any{
+		"owner":  "gofika",
+		"repo":   "http util",
+		"state":  "open",
+		"labels": []string{"bug", "help wanted"},
+	}))
+	assert.Nil(t, err)
+	body, _ := ReadString(resp)
+	assert.Equal(t, "/repos/gofika/http%20util/issues?state=open&labels=bug&labels=help%20wanted", body)
+
+	resp, err = c.PostJSON("/items/{id}", map[string]string{}, WithURITemplate(map[string]any{"id": 7}))
+	assert.Nil(t, err)
+	body, _ = ReadString(resp)
+	assert.Equal(t, "/items/7", body)
+
+	_, err = c.Get("/items/{id", WithURITemplate(nil))
+	assert.NotNil(t, err)
+}